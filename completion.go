@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// completionFlag is a flag offered by shell completion, with the choices for
+// its value if the usage text lists them, as in "--theme <auto|dark|light>".
+type completionFlag struct {
+	name    string
+	choices []string
+}
+
+// usageFlag matches a flag at the start of a line of the usage text, as in
+// the options list or "  diffwatch --save <name>", with its value placeholder
+// if it has one. Flags mentioned in passing, like delta's, aren't matched.
+var usageFlag = regexp.MustCompile(`(?m)^  (?:diffwatch |-[a-z], )?(--[a-z][a-z-]*)(?: <([^>]+)>)?`)
+
+// completionFlags lists every flag in the usage text, sorted, so completion
+// can't fall behind --help. --dry-run is only described in prose there.
+func completionFlags() []completionFlag {
+	seen := map[string]bool{"--help": true, "--dry-run": true}
+	flags := []completionFlag{{name: "--help"}, {name: "--dry-run"}}
+	for _, match := range usageFlag.FindAllStringSubmatch(usage, -1) {
+		if seen[match[1]] {
+			continue
+		}
+		seen[match[1]] = true
+		flag := completionFlag{name: match[1]}
+		if strings.Contains(match[2], "|") {
+			flag.choices = strings.Split(match[2], "|")
+		}
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].name < flags[j].name
+	})
+	return flags
+}
+
+// printProfileNames prints the saved profile names, one per line, for the
+// completion scripts. A config that can't be read prints nothing.
+func printProfileNames() {
+	cfg, err := loadConfig()
+	if err != nil {
+		return
+	}
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+// printCompletion prints the completion script for shell. The scripts run
+// diffwatch --profile-names as they complete, so new profiles show up without
+// regenerating them.
+func printCompletion(shell string) error {
+	flags := completionFlags()
+	var names []string
+	for _, f := range flags {
+		names = append(names, f.name)
+	}
+
+	var b strings.Builder
+	switch shell {
+	case "bash":
+		b.WriteString("# bash completion for diffwatch\n")
+		b.WriteString("_diffwatch() {\n")
+		b.WriteString("    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}\n")
+		b.WriteString("    case $prev in\n")
+		b.WriteString("    --delete|--save)\n")
+		b.WriteString("        COMPREPLY=($(compgen -W \"$(diffwatch --profile-names 2>/dev/null)\" -- \"$cur\"))\n")
+		b.WriteString("        return ;;\n")
+		for _, f := range flags {
+			if f.choices != nil {
+				fmt.Fprintf(&b, "    %s)\n        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n        return ;;\n",
+					f.name, strings.Join(f.choices, " "))
+			}
+		}
+		b.WriteString("    esac\n")
+		b.WriteString("    if [[ $cur == -* ]]; then\n")
+		fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+		b.WriteString("        return\n")
+		b.WriteString("    fi\n")
+		b.WriteString("    COMPREPLY=($(compgen -W \"$(diffwatch --profile-names 2>/dev/null)\" -- \"$cur\") $(compgen -f -- \"$cur\"))\n")
+		b.WriteString("}\n")
+		b.WriteString("complete -o filenames -F _diffwatch diffwatch\n")
+	case "zsh":
+		b.WriteString("#compdef diffwatch\n")
+		b.WriteString("_diffwatch() {\n")
+		b.WriteString("    local -a profiles\n")
+		b.WriteString("    profiles=(${(f)\"$(diffwatch --profile-names 2>/dev/null)\"})\n")
+		b.WriteString("    case $words[CURRENT-1] in\n")
+		b.WriteString("    --delete|--save)\n")
+		b.WriteString("        compadd -a profiles\n")
+		b.WriteString("        return ;;\n")
+		for _, f := range flags {
+			if f.choices != nil {
+				fmt.Fprintf(&b, "    %s)\n        compadd %s\n        return ;;\n", f.name, strings.Join(f.choices, " "))
+			}
+		}
+		b.WriteString("    esac\n")
+		b.WriteString("    if [[ $PREFIX == -* ]]; then\n")
+		fmt.Fprintf(&b, "        compadd -- %s\n", strings.Join(names, " "))
+		b.WriteString("        return\n")
+		b.WriteString("    fi\n")
+		b.WriteString("    compadd -a profiles\n")
+		b.WriteString("    _files\n")
+		b.WriteString("}\n")
+		b.WriteString("compdef _diffwatch diffwatch\n")
+	case "fish":
+		b.WriteString("# fish completion for diffwatch\n")
+		b.WriteString("complete -c diffwatch -a '(diffwatch --profile-names 2>/dev/null)'\n")
+		for _, f := range flags {
+			name := strings.TrimPrefix(f.name, "--")
+			switch {
+			case f.name == "--delete" || f.name == "--save":
+				fmt.Fprintf(&b, "complete -c diffwatch -l %s -x -a '(diffwatch --profile-names 2>/dev/null)'\n", name)
+			case f.choices != nil:
+				fmt.Fprintf(&b, "complete -c diffwatch -l %s -x -a '%s'\n", name, strings.Join(f.choices, " "))
+			default:
+				fmt.Fprintf(&b, "complete -c diffwatch -l %s\n", name)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown shell %q: use bash, zsh, or fish", shell)
+	}
+	fmt.Print(b.String())
+	return nil
+}
@@ -9,9 +9,28 @@ import (
 	"strings"
 )
 
-// Config holds saved profiles for diffwatch.
+// Config holds saved profiles and settings for diffwatch.
 type Config struct {
-	Profiles map[string][]string `json:"profiles"`
+	Profiles      map[string][]string `json:"profiles"`
+	Pager         string              `json:"pager,omitempty"`         // diff rendering command, defaults to delta
+	ShowClean     bool                `json:"showClean,omitempty"`     // show repos without changes instead of pruning them
+	Keys          map[string][]string `json:"keys,omitempty"`          // key binding overrides, action name -> keys
+	DiffTimeout   string              `json:"diffTimeout,omitempty"`   // e.g. "10s"; "0" disables the limit
+	AutoSelect    string              `json:"autoSelect,omitempty"`    // "on", "off", or "idle"
+	Theme         string              `json:"theme,omitempty"`         // "auto", "dark", or "light"
+	MaxRepos      *int                `json:"maxRepos,omitempty"`      // discovery limit per path; 0 disables it
+	MaxDepth      int                 `json:"maxDepth,omitempty"`      // directory levels discovery descends; 0 is unlimited
+	NoRenames     bool                `json:"noRenames,omitempty"`     // skip rename detection in git status
+	UntrackedDirs bool                `json:"untrackedDirs,omitempty"` // list untracked directories, not their files
+	Notify        bool                `json:"notify,omitempty"`        // desktop notification when new files change
+	TabWidth      int                 `json:"tabWidth,omitempty"`      // columns per tab in delta's output
+	NameTemplate  string              `json:"nameTemplate,omitempty"`  // repo display names, e.g. "{parent}/{base}"
+	Coalesce      string              `json:"coalesce,omitempty"`      // e.g. "3s"; minimum time between a repo's updates
+	Reconcile     string              `json:"reconcile,omitempty"`     // e.g. "30s"; how often cached line counts are reread; "0" never
+	StatusColors  map[string]string   `json:"statusColors,omitempty"`  // status letter -> ANSI index or hex color
+	AbsolutePaths bool                `json:"absolutePaths,omitempty"` // show files' absolute paths in the tree
+	MaxLineLength *int                `json:"maxLineLength,omitempty"` // cut longer diff lines; 0 keeps them whole
+	Session       *bool               `json:"session,omitempty"`       // false stops saving the session on quit
 }
 
 // configPath returns the path to the config file.
@@ -83,8 +102,9 @@ func listProfiles() {
 	}
 }
 
-// saveProfile saves a named profile with the given paths.
-func saveProfile(name string, paths []string) {
+// saveProfile saves a named profile with the given paths. With dryRun, it
+// prints the change to the config instead of writing it.
+func saveProfile(name string, paths []string, dryRun bool) {
 	cfg, err := loadConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
@@ -106,6 +126,10 @@ func saveProfile(name string, paths []string) {
 		}
 	}
 
+	if dryRun {
+		printProfileChange(name, cfg.Profiles[name], storedPaths)
+		return
+	}
 	cfg.Profiles[name] = storedPaths
 	if err := saveConfig(cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
@@ -114,8 +138,9 @@ func saveProfile(name string, paths []string) {
 	fmt.Printf("Saved profile '%s': %s\n", name, strings.Join(storedPaths, " "))
 }
 
-// deleteProfile removes a saved profile.
-func deleteProfile(name string) {
+// deleteProfile removes a saved profile. With dryRun, it prints the change to
+// the config instead of writing it.
+func deleteProfile(name string, dryRun bool) {
 	cfg, err := loadConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
@@ -125,6 +150,10 @@ func deleteProfile(name string) {
 		fmt.Fprintf(os.Stderr, "Profile '%s' not found.\n", name)
 		os.Exit(1)
 	}
+	if dryRun {
+		printProfileChange(name, cfg.Profiles[name], nil)
+		return
+	}
 	delete(cfg.Profiles, name)
 	if err := saveConfig(cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
@@ -133,6 +162,18 @@ func deleteProfile(name string) {
 	fmt.Printf("Deleted profile '%s'.\n", name)
 }
 
+// printProfileChange prints a diff-style summary of replacing a profile's
+// paths, where nil old or new paths mean the profile is added or removed.
+func printProfileChange(name string, oldPaths, newPaths []string) {
+	fmt.Printf("Dry run, %s not changed:\n", configPath())
+	if oldPaths != nil {
+		fmt.Printf("- %s: %s\n", name, strings.Join(oldPaths, " "))
+	}
+	if newPaths != nil {
+		fmt.Printf("+ %s: %s\n", name, strings.Join(newPaths, " "))
+	}
+}
+
 // resolveProfile checks if a single arg matches a profile name and returns expanded paths.
 // Returns nil if no profile matches.
 func resolveProfile(name string) []string {
@@ -150,3 +191,63 @@ func resolveProfile(name string) []string {
 	}
 	return expanded
 }
+
+// Session is the state of the last run, saved on quit so --resume can pick up
+// where it left off. It lives in its own file next to the config so that
+// quitting never rewrites the user's settings.
+type Session struct {
+	Profiles []string `json:"profiles,omitempty"` // saved profiles that were watched
+	Paths    []string `json:"paths,omitempty"`    // absolute paths that were watched
+
+	SelectedRepo string `json:"selectedRepo,omitempty"` // WatchPath of the selected file's repo
+	SelectedFile string `json:"selectedFile,omitempty"` // the selected file, relative to its repo root
+
+	DiffFocused bool            `json:"diffFocused,omitempty"`
+	Zoomed      bool            `json:"zoomed,omitempty"`
+	Collapsed   map[string]bool `json:"collapsed,omitempty"` // repo WatchPath -> collapsed, for repos the user folded or unfolded
+	Pinned      []string        `json:"pinned,omitempty"`    // fileKeys of pinned files
+	Flat        bool            `json:"flat,omitempty"`
+	NoUntracked bool            `json:"noUntracked,omitempty"`
+	Legend      bool            `json:"legend,omitempty"`
+	Absolute    bool            `json:"absolute,omitempty"`
+
+	Context          int  `json:"context"`
+	IgnoreWhitespace bool `json:"ignoreWhitespace,omitempty"`
+}
+
+// sessionPath returns the path to the session file.
+func sessionPath() string {
+	return filepath.Join(filepath.Dir(configPath()), "session.json")
+}
+
+// loadSession reads the session saved by the last run.
+func loadSession() (*Session, error) {
+	data, err := os.ReadFile(sessionPath())
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no session to resume; one is saved when diffwatch quits")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid session file %s: %v", sessionPath(), err)
+	}
+	if len(s.Profiles) == 0 && len(s.Paths) == 0 {
+		return nil, fmt.Errorf("session file %s has no paths to watch", sessionPath())
+	}
+	return &s, nil
+}
+
+// saveSession writes s to the session file.
+func saveSession(s *Session) error {
+	path := sessionPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -1,35 +1,57 @@
 package main
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
 )
 
 // DiffLoadedMsg is sent when a diff has been loaded for a file.
 type DiffLoadedMsg struct {
-	File    ChangedFile
-	Content string // ANSI string from delta
+	File    diffwatch.ChangedFile
+	Content string // ANSI string from the pager
 	Err     error
+
+	Opts diffwatch.DiffOptions // how the diff was loaded, to explain an empty one
 }
 
 // DiffViewModel is the right panel showing a scrollable, syntax-highlighted diff.
 type DiffViewModel struct {
 	viewport viewport.Model
 	filePath string // currently displayed file path for header
+	fileKey  string // fileKey of the displayed file
 	loading  bool
 	width    int
 	height   int
 	lines    []string // split content for hunk navigation
+	cursor   int      // index into lines of the highlighted line that line-level actions apply to
+	count    int      // pending vim-style numeric prefix, 0 if none
+	spinner  spinner.Model
+	keys     KeyMap
+	theme    Theme
+
+	lineFilter byte     // '+' or '-' to show only added or removed lines, 0 to show all
+	allLines   []string // the loaded diff's lines, before lineFilter is applied
+	lineIndex  []int    // index into allLines of each of lines, nil when not filtering
 }
 
 // NewDiffViewModel creates a new DiffViewModel.
-func NewDiffViewModel() DiffViewModel {
+func NewDiffViewModel(keys KeyMap, theme Theme) DiffViewModel {
 	vp := viewport.New(0, 0)
 	return DiffViewModel{
 		viewport: vp,
+		keys:     keys,
+		theme:    theme,
+		spinner:  spinner.New(spinner.WithSpinner(spinner.Dot)),
 	}
 }
 
@@ -45,63 +67,241 @@ func (m DiffViewModel) Update(msg tea.Msg) (DiffViewModel, tea.Cmd) {
 		m.loading = false
 		if msg.Err != nil {
 			m.viewport.SetContent(lipgloss.NewStyle().
-				Foreground(lipgloss.Color("1")).
+				Foreground(m.theme.Error).
 				Render("Error loading diff: " + msg.Err.Error()))
-			m.lines = nil
+			m.lines, m.allLines, m.lineIndex = nil, nil, nil
 			return m, nil
 		}
+		content := msg.Content
+		empty := strings.TrimSpace(ansi.Strip(content)) == ""
+		if empty {
+			content = lipgloss.NewStyle().
+				Faint(true).
+				Padding(1, 2).
+				Width(m.width).
+				Render(m.emptyDiffReason(msg.File, msg.Opts))
+		}
+		if msg.File.Status == "U" {
+			content = highlightConflicts(content, m.theme)
+		}
+		// A reload of the same file, e.g. after staging a hunk, keeps its place
+		reload := fileKey(msg.File) == m.fileKey
 		m.filePath = msg.File.Path
-		m.viewport.SetContent(msg.Content)
-		m.viewport.GotoTop()
-		m.lines = strings.Split(msg.Content, "\n")
+		m.fileKey = fileKey(msg.File)
+		if empty {
+			m.viewport.SetContent(content)
+			m.lines, m.allLines, m.lineIndex = nil, nil, nil // nothing for the cursor or hunk jumps to land on
+		} else {
+			m.allLines = strings.Split(content, "\n")
+			m.applyLineFilter()
+		}
+		if reload {
+			m.moveCursor(m.cursor)
+		} else {
+			m.viewport.GotoTop()
+			m.cursor = 0
+		}
 		return m, nil
 
+	case spinner.TickMsg:
+		// Stop ticking once loading finishes
+		if !m.loading {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
 	case tea.KeyMsg:
 		return m.updateKeys(msg)
 	}
 
 	var cmd tea.Cmd
 	m.viewport, cmd = m.viewport.Update(msg)
+	m.cursorIntoView()
 	return m, cmd
 }
 
 func (m DiffViewModel) updateKeys(msg tea.KeyMsg) (DiffViewModel, tea.Cmd) {
-	switch msg.String() {
-	case "g":
-		m.viewport.GotoTop()
+	// Digits build a count for the next movement, like vim's 5j
+	if digit, ok := countDigit(msg, m.count); ok {
+		m.count = m.count*10 + digit
 		return m, nil
-	case "G":
-		m.viewport.GotoBottom()
+	}
+	count := m.count
+	m.count = 0
+
+	switch m.keys.Action(msg) {
+	case ActionDown:
+		m.moveCursor(m.cursor + max(count, 1))
+		return m, nil
+	case ActionUp:
+		m.moveCursor(m.cursor - max(count, 1))
+		return m, nil
+	case ActionTop:
+		// Like vim's 5gg, a count jumps to that line, same as with G
+		m.moveCursor(max(count, 1) - 1)
+		return m, nil
+	case ActionBottom:
+		// Jump to the counted line, or the bottom without a count
+		if count > 0 {
+			m.moveCursor(count - 1)
+		} else {
+			m.moveCursor(len(m.lines) - 1)
+		}
 		return m, nil
-	case "d", "ctrl+d":
+	case ActionPageDown:
+		m.viewport.ViewDown()
+		m.moveCursor(m.cursor + m.viewport.Height)
+		return m, nil
+	case ActionPageUp:
+		m.viewport.ViewUp()
+		m.moveCursor(m.cursor - m.viewport.Height)
+		return m, nil
+	case ActionHalfPageDown:
 		m.viewport.HalfViewDown()
+		m.moveCursor(m.cursor + m.viewport.Height/2)
 		return m, nil
-	case "u", "ctrl+u":
+	case ActionHalfPageUp:
 		m.viewport.HalfViewUp()
+		m.moveCursor(m.cursor - m.viewport.Height/2)
 		return m, nil
-	case "n":
+	case ActionNextHunk:
 		m.jumpToNextHunk()
 		return m, nil
-	case "N":
+	case ActionPrevHunk:
 		m.jumpToPrevHunk()
 		return m, nil
+	case ActionCopyLine:
+		if m.cursor < len(m.lines) {
+			return m, copyToClipboard(sourceLine(m.lines[m.cursor]), fmt.Sprintf("line %d", m.cursor+1))
+		}
+		return m, nil
+	case ActionFilterLines:
+		m.cycleLineFilter()
+		return m, nil
 	}
 
-	// Default: let viewport handle j/k/up/down scrolling
+	// Default: let viewport handle remaining scroll keys
 	var cmd tea.Cmd
 	m.viewport, cmd = m.viewport.Update(msg)
+	m.cursorIntoView()
 	return m, cmd
 }
 
+// moveCursor puts the line cursor on line, clamped to the diff, scrolling the
+// viewport as little as needed to keep it visible.
+func (m *DiffViewModel) moveCursor(line int) {
+	m.cursor = max(0, min(line, len(m.lines)-1))
+	if m.cursor < m.viewport.YOffset {
+		m.viewport.SetYOffset(m.cursor)
+	} else if bottom := m.viewport.YOffset + m.viewport.Height - 1; m.cursor > bottom {
+		m.viewport.SetYOffset(m.cursor - m.viewport.Height + 1)
+	}
+}
+
+// cursorIntoView moves the line cursor onto the nearest visible line after the
+// viewport scrolled on its own, e.g. with the mouse wheel or page keys.
+func (m *DiffViewModel) cursorIntoView() {
+	top := m.viewport.YOffset
+	bottom := min(top+m.viewport.Height, len(m.lines)) - 1
+	m.cursor = max(top, min(m.cursor, bottom))
+}
+
+// cycleLineFilter switches the diff between showing all lines, only added
+// lines, and only removed lines. The cursor stays on the line it was on, or
+// the next one still shown.
+func (m *DiffViewModel) cycleLineFilter() {
+	switch m.lineFilter {
+	case 0:
+		m.lineFilter = '+'
+	case '+':
+		m.lineFilter = '-'
+	default:
+		m.lineFilter = 0
+	}
+	if m.allLines == nil {
+		return
+	}
+	was := m.sourceIndex(m.cursor)
+	m.applyLineFilter()
+	line := len(m.lines) - 1
+	for i := range m.lines {
+		if m.sourceIndex(i) >= was {
+			line = i
+			break
+		}
+	}
+	m.moveCursor(line)
+}
+
+// applyLineFilter sets lines, and the viewport's content, to allLines with
+// lineFilter applied. Headers are kept so hunks can still be told apart.
+func (m *DiffViewModel) applyLineFilter() {
+	if m.lineFilter == 0 {
+		m.lines, m.lineIndex = m.allLines, nil
+	} else {
+		m.lines, m.lineIndex = nil, nil
+		for i, line := range m.allLines {
+			if kind := diffLineKind(ansi.Strip(line)); kind == 0 || kind == m.lineFilter {
+				m.lines = append(m.lines, line)
+				m.lineIndex = append(m.lineIndex, i)
+			}
+		}
+	}
+	m.viewport.SetContent(strings.Join(m.lines, "\n"))
+}
+
+// sourceIndex returns the index into allLines of line i of lines.
+func (m DiffViewModel) sourceIndex(i int) int {
+	if m.lineIndex == nil {
+		return i
+	}
+	if i < len(m.lineIndex) {
+		return m.lineIndex[i]
+	}
+	return len(m.allLines)
+}
+
+// diffLineKind returns '+' for an added line, '-' for a removed one, ' ' for a
+// context line, or 0 for anything else, like file and hunk headers. plain is
+// a line stripped of ANSI codes, from git diff or from delta --line-numbers,
+// whose gutter has only an old number for a removed line and only a new one
+// for an added line.
+func diffLineKind(plain string) byte {
+	if gutter := deltaGutter.FindString(plain); gutter != "" {
+		before, _, _ := strings.Cut(gutter, "⋮")
+		hasOld := strings.TrimSpace(before) != ""
+		hasNew := deltaNewLine(plain) > 0
+		switch {
+		case hasOld && hasNew:
+			return ' '
+		case hasOld:
+			return '-'
+		case hasNew:
+			return '+'
+		}
+		return 0
+	}
+	if strings.HasPrefix(plain, "+++ ") || strings.HasPrefix(plain, "--- ") {
+		return 0
+	}
+	if plain != "" && strings.ContainsRune("+- ", rune(plain[0])) {
+		return plain[0]
+	}
+	return 0
+}
+
 // jumpToNextHunk moves the viewport to the next @@ hunk header after the current position.
 func (m *DiffViewModel) jumpToNextHunk() {
 	if m.lines == nil {
 		return
 	}
-	currentLine := m.viewport.YOffset
+	currentLine := m.cursor
 	for i := currentLine + 1; i < len(m.lines); i++ {
 		if strings.Contains(m.lines[i], "@@") {
 			m.viewport.SetYOffset(i)
+			m.cursor = i
 			return
 		}
 	}
@@ -112,34 +312,132 @@ func (m *DiffViewModel) jumpToPrevHunk() {
 	if m.lines == nil {
 		return
 	}
-	currentLine := m.viewport.YOffset
+	currentLine := m.cursor
 	for i := currentLine - 1; i >= 0; i-- {
 		if strings.Contains(m.lines[i], "@@") {
 			m.viewport.SetYOffset(i)
+			m.cursor = i
 			return
 		}
 	}
 }
 
+// deltaGutter matches the line numbers delta --line-numbers puts before each
+// line, e.g. "  12 ⋮  13 │".
+var deltaGutter = regexp.MustCompile(`^\s*\d*\s*⋮\s*\d*\s*│`)
+
+// sourceLine returns the file's text on a rendered diff line, without colors,
+// the +/- marker, or delta's line numbers.
+func sourceLine(line string) string {
+	plain := ansi.Strip(line)
+	if loc := deltaGutter.FindStringIndex(plain); loc != nil {
+		return plain[loc[1]:]
+	}
+	if plain != "" && strings.ContainsRune("+- ", rune(plain[0])) {
+		return plain[1:]
+	}
+	return plain
+}
+
+// hunkHeader matches a unified diff hunk header, capturing the new file's
+// starting line.
+var hunkHeader = regexp.MustCompile(`^@@+ [^@]*\+(\d+)(?:,\d+)? @@`)
+
+// CursorSourceLine returns the line number in the working-tree file of the
+// line under the cursor, or 1 if it can't be worked out. A removed line maps
+// to the line that now stands in its place. delta's line-number gutter is
+// read directly; for plain diffs, lines are counted from the hunk header.
+func (m DiffViewModel) CursorSourceLine() int {
+	if m.cursor >= len(m.lines) {
+		return 1
+	}
+	// Count through the whole diff, as a line filter leaves out lines that
+	// are in the new file
+	lines, cursor := m.allLines, m.sourceIndex(m.cursor)
+	// delta numbers every line; a removed line has only an old number, so
+	// take the nearest new number after it, then before it
+	if deltaGutter.MatchString(ansi.Strip(lines[cursor])) {
+		for _, dir := range []int{1, -1} {
+			for i := cursor; i >= 0 && i < len(lines); i += dir {
+				if n := deltaNewLine(ansi.Strip(lines[i])); n > 0 {
+					return n
+				}
+			}
+		}
+		return 1
+	}
+
+	count := 0
+	for i := cursor; i >= 0; i-- {
+		plain := ansi.Strip(lines[i])
+		if match := hunkHeader.FindStringSubmatch(plain); match != nil {
+			start, _ := strconv.Atoi(match[1])
+			return max(start+count, 1)
+		}
+		// Lines above the cursor that are in the new file push it down
+		if i < cursor && plain != "" && (plain[0] == '+' || plain[0] == ' ') {
+			count++
+		}
+	}
+	return 1
+}
+
+// deltaNewLine returns the new-file line number in a line's delta gutter, or 0
+// if it has none, as for removed lines.
+func deltaNewLine(plain string) int {
+	gutter := deltaGutter.FindString(plain)
+	_, after, ok := strings.Cut(gutter, "⋮")
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(after, "│")))
+	return n
+}
+
+// highlightConflicts re-renders conflict marker lines (<<<<<<<, =======, >>>>>>>)
+// so they stand out from the surrounding diff.
+func highlightConflicts(content string, theme Theme) string {
+	conflictStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(theme.OnColor).
+		Background(theme.Conflict)
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		plain := ansi.Strip(line)
+		trimmed := strings.TrimRight(plain, " ")
+		if strings.Contains(plain, "<<<<<<< ") ||
+			strings.Contains(plain, ">>>>>>> ") ||
+			strings.HasSuffix(trimmed, "=======") {
+			lines[i] = conflictStyle.Render(plain)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 // SetSize sets the available width and height for the viewport.
 func (m *DiffViewModel) SetSize(w, h int) {
 	m.width = w
 	m.height = h
 	m.viewport.Width = w
-	m.viewport.Height = h
+	m.viewport.Height = max(h-1, 1) // last line is the position footer
 }
 
-// SetLoading marks the diff view as loading.
-func (m *DiffViewModel) SetLoading() {
+// SetLoading marks the diff view as loading and returns the command that
+// starts the spinner.
+func (m *DiffViewModel) SetLoading() tea.Cmd {
 	m.loading = true
+	return m.spinner.Tick
 }
 
 // Clear resets the diff view to an empty state.
 func (m *DiffViewModel) Clear() {
 	m.filePath = ""
+	m.fileKey = ""
 	m.loading = false
 	m.viewport.SetContent("")
-	m.lines = nil
+	m.lines, m.allLines, m.lineIndex = nil, nil, nil
+	m.cursor = 0
 }
 
 // View implements tea.Model.
@@ -148,7 +446,7 @@ func (m DiffViewModel) View() string {
 		return lipgloss.NewStyle().
 			Faint(true).
 			Padding(1, 2).
-			Render("Loading...")
+			Render(m.spinner.View() + " Loading...")
 	}
 
 	if m.filePath == "" {
@@ -158,17 +456,81 @@ func (m DiffViewModel) View() string {
 			Render("Select a file to view diff")
 	}
 
-	return m.viewport.View()
+	footer := lipgloss.NewStyle().
+		Faint(true).
+		Width(m.width).
+		Align(lipgloss.Right).
+		Render(m.position())
+	return m.highlightCursor(m.viewport.View()) + "\n" + footer
+}
+
+// emptyDiffReason explains why file has no diff to show, so an empty panel
+// doesn't look like a failure to load one.
+func (m DiffViewModel) emptyDiffReason(file diffwatch.ChangedFile, opts diffwatch.DiffOptions) string {
+	switch {
+	case file.Status == "?" || file.Status == "!" || file.Status == "A":
+		return "New file is empty"
+	case file.Status == "D":
+		return "Deleted file was empty"
+	case file.Status == "R":
+		return "Renamed from " + file.OrigPath + " without changes to its content"
+	case file.Status == "C":
+		return "Copied from " + file.OrigPath + " without changes"
+	case opts.IgnoreWhitespace:
+		// Only a modified file can have had its whole diff hidden as whitespace
+		if key := m.keys.Help(ActionToggleWhitespace); key != "" {
+			return fmt.Sprintf("No changes besides whitespace (press %s to show them)", key)
+		}
+		return "No changes besides whitespace"
+	default:
+		return "No textual changes: git sees the file as modified, but its content is the same (e.g. line endings normalized by git, or a changed timestamp)"
+	}
+}
+
+// highlightCursor draws the line cursor's row of view, the rendered viewport,
+// in reverse video. The row loses its own colors so the highlight reads the
+// same on any diff.
+func (m DiffViewModel) highlightCursor(view string) string {
+	if m.lines == nil {
+		return view
+	}
+	rows := strings.Split(view, "\n")
+	row := m.cursor - m.viewport.YOffset
+	if row < 0 || row >= len(rows) {
+		return view
+	}
+	rows[row] = lipgloss.NewStyle().Reverse(true).Width(m.width).MaxWidth(m.width).Render(ansi.Strip(rows[row]))
+	return strings.Join(rows, "\n")
+}
+
+// position describes where the viewport is within the diff, e.g. "120-160/300 42%",
+// noting a line filter.
+func (m DiffViewModel) position() string {
+	total := m.viewport.TotalLineCount()
+	if total == 0 {
+		return ""
+	}
+	first := m.viewport.YOffset + 1
+	last := min(m.viewport.YOffset+m.viewport.Height, total)
+	pos := fmt.Sprintf("%d-%d/%d %3.f%%", first, last, total, m.viewport.ScrollPercent()*100)
+	switch m.lineFilter {
+	case '+':
+		pos = "added lines only  " + pos
+	case '-':
+		pos = "removed lines only  " + pos
+	}
+	return pos
 }
 
 // loadDiff returns a tea.Cmd that loads the diff for a file asynchronously.
-func loadDiff(file ChangedFile) tea.Cmd {
+func loadDiff(file diffwatch.ChangedFile, opts diffwatch.DiffOptions) tea.Cmd {
 	return func() tea.Msg {
-		content, err := GetDiff(file)
+		content, err := diffwatch.GetDiff(file, opts)
 		return DiffLoadedMsg{
 			File:    file,
 			Content: content,
 			Err:     err,
+			Opts:    opts,
 		}
 	}
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
+)
+
+// git runs git in dir, failing the test if it errors.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// twoHunkRepo returns a repo whose file f has two changes far enough apart
+// to be separate hunks: line 3 and line 17 of 20.
+func twoHunkRepo(t *testing.T) diffwatch.ChangedFile {
+	t.Helper()
+	dir := t.TempDir()
+	git(t, dir, "init", "-q")
+	git(t, dir, "config", "user.email", "test@example.com")
+	git(t, dir, "config", "user.name", "test")
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	write := func() {
+		if err := os.WriteFile(filepath.Join(dir, "f"), []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write()
+	git(t, dir, "add", "f")
+	git(t, dir, "commit", "-q", "-m", "init")
+	lines[2], lines[16] = "changed 3", "changed 17"
+	write()
+
+	repo := &diffwatch.Repo{Name: "r", Path: dir, WatchPath: dir}
+	status, err := diffwatch.GetRepoStatus(repo, diffwatch.StatusOptions{})
+	if err != nil || len(status.Files) != 1 {
+		t.Fatalf("status: %v, %d files", err, len(status.Files))
+	}
+	return status.Files[0]
+}
+
+// renderLikeDelta renders a plain git diff the way delta does with
+// deltaFlags: no file or hunk headers, and a line-number gutter.
+func renderLikeDelta(diff string) string {
+	var out []string
+	oldLine, newLine := 0, 0
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		if m := hunkHeader.FindStringSubmatch(line); m != nil {
+			fmt.Sscanf(line, "@@ -%d", &oldLine)
+			fmt.Sscanf(m[1], "%d", &newLine)
+			continue
+		}
+		if oldLine == 0 || line == "" {
+			continue // file header
+		}
+		switch line[0] {
+		case '-':
+			out = append(out, fmt.Sprintf("%4d ⋮     │%s", oldLine, line[1:]))
+			oldLine++
+		case '+':
+			out = append(out, fmt.Sprintf("     ⋮ %4d │%s", newLine, line[1:]))
+			newLine++
+		default:
+			out = append(out, fmt.Sprintf("%4d ⋮ %4d │%s", oldLine, newLine, line[1:]))
+			oldLine++
+			newLine++
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// TestStageHunkWithDelta presses s on the second change of a diff rendered
+// without hunk headers, as delta renders it, and checks only that change is
+// staged.
+func TestStageHunkWithDelta(t *testing.T) {
+	file := twoHunkRepo(t)
+	opts := diffwatch.DiffOptions{Context: defaultContext}
+
+	content := renderLikeDelta(git(t, file.Repo.Path, "diff", "--no-color", "-U3"))
+	if _, err := exec.LookPath("delta"); err == nil {
+		opts.Pager = "delta"
+		rendered, err := diffwatch.GetDiff(file, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = rendered
+	}
+	if strings.Contains(content, "@@") {
+		t.Fatalf("rendered diff has hunk headers:\n%s", content)
+	}
+
+	keys, _ := NewKeyMap(nil)
+	m := NewModel([]diffwatch.Repo{*file.Repo}, nil, repoSource{}, Options{}, keys, NewTheme(ThemeDark))
+	m.diffOpts = opts
+	m.focus = RightPanel
+	m.filetree.selected = &file
+	m.diffview.SetSize(80, 40)
+	m.diffview, _ = m.diffview.Update(DiffLoadedMsg{File: file, Content: content, Opts: opts})
+	cursor := -1
+	for i, line := range m.diffview.lines {
+		if strings.Contains(line, "changed 17") {
+			cursor = i
+		}
+	}
+	if cursor < 0 {
+		t.Fatalf("no changed line in the rendered diff:\n%s", content)
+	}
+	m.diffview.moveCursor(cursor)
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'s'}})
+	if cmd == nil {
+		t.Fatalf("s did nothing: %v", m.statusErr)
+	}
+	msg, ok := cmd().(hunkStagedMsg)
+	if !ok {
+		t.Fatalf("s returned %T, want hunkStagedMsg", cmd())
+	}
+	if msg.err != nil || msg.hunk != 1 {
+		t.Fatalf("staged hunk %d, err %v; want hunk 1", msg.hunk, msg.err)
+	}
+	staged := git(t, file.Repo.Path, "diff", "--cached")
+	if !strings.Contains(staged, "+changed 17") || strings.Contains(staged, "+changed 3") {
+		t.Errorf("staged diff isn't just the second hunk:\n%s", staged)
+	}
+}
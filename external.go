@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
+)
+
+// fileManagerCommand returns the command that opens dir in the OS file manager.
+func fileManagerCommand(dir string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", dir)
+	case "windows":
+		return exec.Command("explorer", dir)
+	default:
+		return exec.Command("xdg-open", dir)
+	}
+}
+
+// notifierCommand returns the command that shows a desktop notification, or
+// nil if neither terminal-notifier (macOS) nor notify-send (Linux) is installed.
+func notifierCommand(title, message string) *exec.Cmd {
+	if _, err := exec.LookPath("terminal-notifier"); err == nil {
+		return exec.Command("terminal-notifier", "-title", title, "-message", message)
+	}
+	if _, err := exec.LookPath("notify-send"); err == nil {
+		return exec.Command("notify-send", title, message)
+	}
+	return nil
+}
+
+// notify returns a tea.Cmd that shows a desktop notification.
+func notify(title, message string) tea.Cmd {
+	return func() tea.Msg {
+		cmd := notifierCommand(title, message)
+		if cmd == nil {
+			return nil
+		}
+		if err := cmd.Run(); err != nil {
+			return StatusErrMsg{Err: fmt.Errorf("could not send notification: %w", err)}
+		}
+		return nil
+	}
+}
+
+// execDoneMsg reports that an --exec command finished for a repo.
+type execDoneMsg struct {
+	repo *diffwatch.Repo
+	err  error
+}
+
+// runExecHook returns a tea.Cmd that runs command with sh in the root of
+// msg's repo, describing the change in environment variables. Its output is
+// kept only to explain a failure, so it can't draw over the TUI. The command
+// is killed when ctx is canceled or after timeout (0 means no limit).
+func runExecHook(ctx context.Context, command string, timeout time.Duration, msg FilesChangedMsg) tea.Cmd {
+	return func() tea.Msg {
+		paths := make([]string, len(msg.Files))
+		for i, f := range msg.Files {
+			paths[i] = f.Path
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		// Don't wait on pipes held open by the command's own children once
+		// it's killed
+		cmd.WaitDelay = time.Second
+		cmd.Dir = msg.Repo.Path
+		cmd.Env = append(os.Environ(),
+			"DIFFWATCH_REPO="+msg.Repo.WatchPath,
+			"DIFFWATCH_REPO_NAME="+msg.Repo.Name,
+			"DIFFWATCH_FILES="+strings.Join(paths, "\n"),
+		)
+		out, err := cmd.CombinedOutput()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("--exec in %s: killed after %s", msg.Repo.Name, timeout)
+		} else if err != nil {
+			// The last line of output usually says what went wrong
+			lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+			if last := lines[len(lines)-1]; last != "" {
+				err = fmt.Errorf("%w: %s", err, last)
+			}
+			err = fmt.Errorf("--exec in %s: %w", msg.Repo.Name, err)
+		}
+		return execDoneMsg{repo: msg.Repo, err: err}
+	}
+}
+
+// editorCommand returns the command that opens path at line in $VISUAL or
+// $EDITOR (default vi), using the line syntax the editor understands.
+func editorCommand(path string, line int) *exec.Cmd {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	fields := strings.Fields(editor)
+	args := fields[1:]
+	switch filepath.Base(fields[0]) {
+	case "code", "code-insiders", "codium", "cursor", "windsurf":
+		args = append(args, "--goto", fmt.Sprintf("%s:%d", path, line))
+	case "subl", "zed", "hx", "helix", "micro":
+		args = append(args, fmt.Sprintf("%s:%d", path, line))
+	default:
+		// vi, vim, nvim, emacs, nano, kak and most others
+		args = append(args, fmt.Sprintf("+%d", line), path)
+	}
+	return exec.Command(fields[0], args...)
+}
+
+// openInEditor returns a tea.Cmd that suspends the TUI and opens file at line
+// in the user's editor. The TUI is restored when the editor exits.
+func openInEditor(file diffwatch.ChangedFile, line int) tea.Cmd {
+	path := filepath.Join(file.Repo.Path, file.Path)
+	if _, err := os.Stat(path); err != nil {
+		return func() tea.Msg {
+			return StatusErrMsg{Err: fmt.Errorf("can't edit %s: %w", file.Path, err)}
+		}
+	}
+	return tea.ExecProcess(editorCommand(path, max(line, 1)), func(err error) tea.Msg {
+		if err != nil {
+			return StatusErrMsg{Err: fmt.Errorf("editor: %w", err)}
+		}
+		return nil
+	})
+}
+
+// clipboardCommand returns the command that copies its stdin to the system
+// clipboard, or nil if no clipboard tool is installed.
+func clipboardCommand() *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("pbcopy")
+	case "windows":
+		return exec.Command("clip")
+	}
+	candidates := [][]string{{"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append([][]string{{"wl-copy"}}, candidates...)
+	}
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c[0]); err == nil {
+			return exec.Command(c[0], c[1:]...)
+		}
+	}
+	return nil
+}
+
+// copyToClipboard returns a tea.Cmd that puts text on the clipboard and
+// confirms with what. Without a clipboard tool, e.g. over SSH, it asks the
+// terminal to do it with an OSC 52 escape sequence.
+func copyToClipboard(text, what string) tea.Cmd {
+	return func() tea.Msg {
+		cmd := clipboardCommand()
+		if cmd == nil {
+			seq := osc52.New(text)
+			if os.Getenv("TMUX") != "" {
+				seq = seq.Tmux()
+			}
+			// stderr is the terminal too, and bubbletea doesn't draw on it
+			if _, err := seq.WriteTo(os.Stderr); err != nil {
+				return StatusErrMsg{Err: fmt.Errorf("could not copy: %w", err)}
+			}
+			return StatusInfoMsg{Text: "Copied " + what}
+		}
+		cmd.Stdin = strings.NewReader(text)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			if msg := strings.TrimSpace(string(out)); msg != "" {
+				err = errors.New(msg)
+			}
+			return StatusErrMsg{Err: fmt.Errorf("could not copy: %w", err)}
+		}
+		return StatusInfoMsg{Text: "Copied " + what}
+	}
+}
+
+// revealFile returns a tea.Cmd that opens the directory containing file in the
+// OS file manager. Deleted files reveal their nearest existing parent directory.
+func revealFile(file diffwatch.ChangedFile) tea.Cmd {
+	return func() tea.Msg {
+		dir := filepath.Dir(filepath.Join(file.Repo.Path, file.Path))
+		for dir != file.Repo.Path {
+			if _, err := os.Stat(dir); err == nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+
+		cmd := fileManagerCommand(dir)
+		if err := cmd.Start(); err != nil {
+			return StatusErrMsg{Err: fmt.Errorf("could not open %s: %w", dir, err)}
+		}
+		// The file manager runs independently; reap it in the background
+		go cmd.Wait()
+		return nil
+	}
+}
+
+// defaultExternalPager is used by openInPager when $PAGER is unset.
+const defaultExternalPager = "less -R"
+
+// openInPager returns a tea.Cmd that suspends the TUI and shows file's diff,
+// rendered as in the diff panel, in $PAGER (default "less -R"). The TUI is
+// restored when the pager exits.
+func openInPager(file diffwatch.ChangedFile, opts diffwatch.DiffOptions) tea.Cmd {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = defaultExternalPager
+	}
+	cmd := exec.Command("bash", "-c", diffwatch.DiffShellCommand(file, opts)+" | "+pager)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		if err != nil {
+			return StatusErrMsg{Err: fmt.Errorf("pager: %w", err)}
+		}
+		return nil
+	})
+}
+
+// hunkStagedMsg reports the outcome of stageHunk.
+type hunkStagedMsg struct {
+	file diffwatch.ChangedFile
+	hunk int
+	err  error
+}
+
+// stageHunk returns a tea.Cmd that stages the hunk of file's diff covering
+// line of the working-tree file.
+func stageHunk(file diffwatch.ChangedFile, line int, opts diffwatch.DiffOptions) tea.Cmd {
+	return func() tea.Msg {
+		hunk, err := diffwatch.StageHunk(file, line, opts)
+		return hunkStagedMsg{file: file, hunk: hunk, err: err}
+	}
+}
+
+// exportPatch returns a tea.Cmd that writes a patch of repo's uncommitted changes
+// (only staged ones if staged is set) to "<repo>-<timestamp>.patch" in the
+// current directory.
+func exportPatch(repo *diffwatch.Repo, staged bool) tea.Cmd {
+	return func() tea.Msg {
+		patch, err := diffwatch.GetRepoDiff(repo, staged)
+		if err != nil {
+			return StatusErrMsg{Err: fmt.Errorf("could not export %s: %w", repo.Name, err)}
+		}
+		if patch == "" {
+			return StatusInfoMsg{Text: "No changes to export in " + repo.Name}
+		}
+
+		name := strings.ReplaceAll(repo.Name, "/", "-")
+		if staged {
+			name += "-staged"
+		}
+		file := fmt.Sprintf("%s-%s.patch", name, time.Now().Format("20060102-150405"))
+		if err := os.WriteFile(file, []byte(patch), 0o644); err != nil {
+			return StatusErrMsg{Err: fmt.Errorf("could not write %s: %w", file, err)}
+		}
+		return StatusInfoMsg{Text: "Wrote " + file}
+	}
+}
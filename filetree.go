@@ -2,44 +2,105 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
-	"unicode/utf8"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
 )
 
 // FileSelectedMsg is sent when the user selects a file in the tree.
 type FileSelectedMsg struct {
-	File ChangedFile
+	File diffwatch.ChangedFile
 }
 
 // RepoGroup represents a repo and its changed files in the tree view.
 type RepoGroup struct {
-	Repo      *Repo
-	Files     []ChangedFile
-	Collapsed bool
+	Repo  *diffwatch.Repo
+	Files []diffwatch.ChangedFile
+	State string // in-progress operation such as "REBASING", or ""
+	Err   error  // why the repo couldn't be read last time, or nil; Files are from before the error
+
+	Ahead, Behind int // commits ahead of and behind the upstream branch
 }
 
 // FileTreeModel is the left panel showing a navigable file tree grouped by repo.
 type FileTreeModel struct {
-	repos    []RepoGroup
-	cursor   int          // index into flattened visible items
-	selected *ChangedFile // currently selected file
-	width    int
-	height   int
-	filter   string
+	repos     []RepoGroup
+	cursor    int                    // index into flattened visible items
+	offset    int                    // index of the first visible item drawn, moved only as far as the cursor needs
+	selected  *diffwatch.ChangedFile // currently selected file
+	width     int
+	height    int
+	filter    string
 	filtering bool
+	showClean bool            // show repos without changes instead of hiding them
+	pinned    map[string]bool // fileKey -> pinned to the top of the tree
+	collapsed map[string]bool // repo WatchPath -> collapsed, kept by identity so reordering repos can't misapply it
+	manual    map[string]bool // repo WatchPath -> collapsed state set by the user, which auto collapse/expand leaves alone
+	count     int             // pending vim-style numeric prefix, 0 if none
+	flat      bool            // list every file as "repo: path" instead of grouping by repo
+	keys      KeyMap
+	theme     Theme
+
+	noUntracked bool                 // hide untracked files, which can drown out edits to tracked ones
+	legend      bool                 // explain the status letters below the tree
+	absolute    bool                 // show files' absolute paths instead of repo-relative ones
+	fresh       map[string]time.Time // fileKey -> when the file appeared in its repo's changes, highlighted for freshTime
+
+	autoSelect string    // when to select a file automatically: AutoSelectOn, AutoSelectOff, or AutoSelectIdle
+	lastInput  time.Time // last key press, for AutoSelectIdle
 }
 
+// Auto-select modes, controlling whether a file is selected automatically
+// when nothing is selected.
+const (
+	AutoSelectOn   = "on"   // always
+	AutoSelectOff  = "off"  // never; the user picks a file
+	AutoSelectIdle = "idle" // only once the user has stopped typing for autoSelectIdleTime
+)
+
+// autoSelectIdleTime is how long without key presses counts as idle.
+const autoSelectIdleTime = 3 * time.Second
+
 // NewFileTreeModel creates a new FileTreeModel.
-func NewFileTreeModel() FileTreeModel {
-	return FileTreeModel{}
+func NewFileTreeModel(showClean bool, autoSelect string, keys KeyMap, theme Theme) FileTreeModel {
+	return FileTreeModel{
+		showClean:  showClean,
+		autoSelect: autoSelect,
+		keys:       keys,
+		theme:      theme,
+		pinned:     make(map[string]bool),
+		collapsed:  make(map[string]bool),
+		manual:     make(map[string]bool),
+		fresh:      make(map[string]time.Time),
+	}
+}
+
+// freshTime is how long a file that just appeared in the tree stays highlighted.
+const freshTime = 3 * time.Second
+
+// freshExpiredMsg is sent freshTime after files were highlighted, to clear them.
+type freshExpiredMsg struct{}
+
+// autoSelectIdleMsg is sent autoSelectIdleTime after a key press, so
+// AutoSelectIdle selects a file once typing stops even if no change comes in.
+type autoSelectIdleMsg struct{}
+
+// fileKey identifies a changed file across refreshes.
+func fileKey(f diffwatch.ChangedFile) string {
+	return f.Repo.WatchPath + "\x00" + f.Path
 }
 
 // flatItem represents a single row in the flattened tree view.
 type flatItem struct {
 	isRepo    bool
+	pinned    bool // file row in the pinned section
 	repoIndex int
 	fileIndex int // -1 for repo headers
 }
@@ -47,13 +108,35 @@ type flatItem struct {
 // visibleItems returns the flattened list of currently visible items.
 func (m *FileTreeModel) visibleItems() []flatItem {
 	var items []flatItem
+
+	// Pinned files come first, regardless of repo grouping
+	for ri := range m.repos {
+		for fi, f := range m.filteredFiles(ri) {
+			if m.pinned[fileKey(f)] {
+				items = append(items, flatItem{pinned: true, repoIndex: ri, fileIndex: fi})
+			}
+		}
+	}
+
+	if m.flat {
+		return append(items, m.flatFileItems()...)
+	}
+
 	for ri, rg := range m.repos {
 		// Skip repos with no files matching filter
 		if m.filter != "" && len(m.filteredFiles(ri)) == 0 {
 			continue
 		}
+		// Likewise repos whose only changes are hidden untracked files
+		if m.noUntracked && len(rg.Files) > 0 && rg.Err == nil && len(m.filteredFiles(ri)) == 0 {
+			continue
+		}
+		// Skip clean repos unless they're shown; failing repos are never hidden
+		if len(rg.Files) == 0 && rg.Err == nil && !m.showClean {
+			continue
+		}
 		items = append(items, flatItem{isRepo: true, repoIndex: ri, fileIndex: -1})
-		if !rg.Collapsed {
+		if !m.collapsed[rg.Repo.WatchPath] {
 			files := m.filteredFiles(ri)
 			for fi := range files {
 				items = append(items, flatItem{isRepo: false, repoIndex: ri, fileIndex: fi})
@@ -63,13 +146,54 @@ func (m *FileTreeModel) visibleItems() []flatItem {
 	return items
 }
 
-// filteredFiles returns files matching the current filter for a repo.
-func (m *FileTreeModel) filteredFiles(repoIndex int) []ChangedFile {
-	if m.filter == "" {
+// flatFileItems lists every file matching the filter across all repos,
+// sorted by repo name and then path, ignoring repo collapsing.
+func (m *FileTreeModel) flatFileItems() []flatItem {
+	var items []flatItem
+	var paths []string
+	for ri := range m.repos {
+		for fi, f := range m.filteredFiles(ri) {
+			items = append(items, flatItem{repoIndex: ri, fileIndex: fi})
+			paths = append(paths, f.Path)
+		}
+	}
+	sort.Sort(byRepoAndPath{m.repos, items, paths})
+	return items
+}
+
+// byRepoAndPath sorts flat file items by repo name, then path.
+type byRepoAndPath struct {
+	repos []RepoGroup
+	items []flatItem
+	paths []string // paths[i] is the path of items[i]
+}
+
+func (s byRepoAndPath) Len() int { return len(s.items) }
+
+func (s byRepoAndPath) Less(i, j int) bool {
+	a, b := s.repos[s.items[i].repoIndex].Repo.Name, s.repos[s.items[j].repoIndex].Repo.Name
+	if a != b {
+		return a < b
+	}
+	return s.paths[i] < s.paths[j]
+}
+
+func (s byRepoAndPath) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	s.paths[i], s.paths[j] = s.paths[j], s.paths[i]
+}
+
+// filteredFiles returns files matching the current filter for a repo,
+// leaving out untracked files while they're hidden.
+func (m *FileTreeModel) filteredFiles(repoIndex int) []diffwatch.ChangedFile {
+	if m.filter == "" && !m.noUntracked {
 		return m.repos[repoIndex].Files
 	}
-	var filtered []ChangedFile
+	var filtered []diffwatch.ChangedFile
 	for _, f := range m.repos[repoIndex].Files {
+		if m.noUntracked && f.Status == "?" {
+			continue
+		}
 		if strings.Contains(strings.ToLower(f.Path), strings.ToLower(m.filter)) {
 			filtered = append(filtered, f)
 		}
@@ -77,11 +201,16 @@ func (m *FileTreeModel) filteredFiles(repoIndex int) []ChangedFile {
 	return filtered
 }
 
-// totalFileCount returns the total number of changed files across all repos.
+// totalFileCount returns the total number of changed files across all repos,
+// not counting hidden untracked files.
 func (m *FileTreeModel) totalFileCount() int {
 	count := 0
 	for _, rg := range m.repos {
-		count += len(rg.Files)
+		for _, f := range rg.Files {
+			if !m.noUntracked || f.Status != "?" {
+				count++
+			}
+		}
 	}
 	return count
 }
@@ -93,17 +222,37 @@ func (m FileTreeModel) Init() tea.Cmd {
 
 // Update implements tea.Model.
 func (m FileTreeModel) Update(msg tea.Msg) (FileTreeModel, tea.Cmd) {
+	var cmd tea.Cmd
 	switch msg := msg.(type) {
 	case FilesChangedMsg:
-		return m.handleFilesChanged(msg)
+		m, cmd = m.handleFilesChanged(msg)
+
+	case freshExpiredMsg:
+		for key, at := range m.fresh {
+			if time.Since(at) >= freshTime {
+				delete(m.fresh, key)
+			}
+		}
+
+	case autoSelectIdleMsg:
+		// Ticks from earlier key presses find the user not yet idle
+		cmd = m.autoSelectFirst()
 
 	case tea.KeyMsg:
+		m.lastInput = time.Now()
 		if m.filtering {
-			return m.updateFilter(msg)
+			m, cmd = m.updateFilter(msg)
+		} else {
+			m, cmd = m.updateNavigation(msg)
+		}
+		if m.autoSelect == AutoSelectIdle && m.selected == nil {
+			cmd = tea.Batch(cmd, tea.Tick(autoSelectIdleTime, func(time.Time) tea.Msg {
+				return autoSelectIdleMsg{}
+			}))
 		}
-		return m.updateNavigation(msg)
 	}
-	return m, nil
+	m.offset = m.scrollOffset()
+	return m, cmd
 }
 
 func (m FileTreeModel) updateFilter(msg tea.KeyMsg) (FileTreeModel, tea.Cmd) {
@@ -134,42 +283,186 @@ func (m FileTreeModel) updateNavigation(msg tea.KeyMsg) (FileTreeModel, tea.Cmd)
 		return m, nil
 	}
 
-	switch msg.String() {
-	case "j", "down":
-		if m.cursor < len(items)-1 {
-			m.cursor++
+	// Digits build a count for the next movement, like vim's 5j
+	if digit, ok := countDigit(msg, m.count); ok {
+		m.count = m.count*10 + digit
+		return m, nil
+	}
+	count := m.count
+	m.count = 0
+
+	switch m.keys.Action(msg) {
+	case ActionDown:
+		m.cursor = min(m.cursor+max(count, 1), len(items)-1)
+		return m, m.selectFileAtCursor()
+	case ActionUp:
+		m.cursor = max(m.cursor-max(count, 1), 0)
+		return m, m.selectFileAtCursor()
+	case ActionNextRepo:
+		for n := max(count, 1); n > 0; n-- {
+			m.cursor = nextRepoHeader(items, m.cursor, 1)
 		}
 		return m, m.selectFileAtCursor()
-	case "k", "up":
-		if m.cursor > 0 {
-			m.cursor--
+	case ActionPrevRepo:
+		for n := max(count, 1); n > 0; n-- {
+			m.cursor = nextRepoHeader(items, m.cursor, -1)
 		}
 		return m, m.selectFileAtCursor()
-	case "enter":
+	case ActionTop:
+		// Like vim's 5gg, a count jumps to that row, same as with G
+		m.cursor = 0
+		if count > 0 {
+			m.cursor = min(count, len(items)) - 1
+		}
+		return m, m.selectFileAtCursor()
+	case ActionBottom:
+		// Jump to the counted row, or the last one without a count
+		m.cursor = len(items) - 1
+		if count > 0 {
+			m.cursor = min(count, len(items)) - 1
+		}
+		return m, m.selectFileAtCursor()
+	case ActionPageDown:
+		// Scroll the view along with the cursor, like a pager
+		page := m.pageRows() * max(count, 1)
+		m.offset = max(min(m.offset+page, len(items)-m.pageRows()), 0)
+		m.cursor = min(m.cursor+page, len(items)-1)
+		return m, m.selectFileAtCursor()
+	case ActionPageUp:
+		page := m.pageRows() * max(count, 1)
+		m.offset = max(m.offset-page, 0)
+		m.cursor = max(m.cursor-page, 0)
+		return m, m.selectFileAtCursor()
+	case ActionSelect:
 		if m.cursor < len(items) {
 			item := items[m.cursor]
 			if item.isRepo {
-				m.repos[item.repoIndex].Collapsed = !m.repos[item.repoIndex].Collapsed
+				m.toggleCollapsed(item.repoIndex)
 				m.clampCursor()
 			}
 			// For files, enter is now redundant since navigation auto-selects,
 			// but keep it as a no-op so users aren't confused.
 		}
-	case "c":
-		if m.cursor < len(items) {
+	case ActionToggleCollapse:
+		// The flat list has no groups to collapse
+		if m.cursor < len(items) && !m.flat {
 			item := items[m.cursor]
-			ri := item.repoIndex
-			m.repos[ri].Collapsed = !m.repos[ri].Collapsed
+			m.toggleCollapsed(item.repoIndex)
 			m.clampCursor()
 		}
-	case "/":
+	case ActionPin:
+		if m.cursor < len(items) && !items[m.cursor].isRepo {
+			item := items[m.cursor]
+			files := m.filteredFiles(item.repoIndex)
+			if item.fileIndex < len(files) {
+				key := fileKey(files[item.fileIndex])
+				if m.pinned[key] {
+					delete(m.pinned, key)
+				} else {
+					m.pinned[key] = true
+				}
+				m.moveCursorToFile(item.repoIndex, item.fileIndex)
+			}
+		}
+	case ActionFilter:
 		m.filtering = true
 		m.filter = ""
+	case ActionToggleFlat:
+		m.flat = !m.flat
+		m.moveCursorToSelected()
+	case ActionHideUntracked:
+		m.noUntracked = !m.noUntracked
+		m.moveCursorToSelected()
+	case ActionToggleAbsolute:
+		m.absolute = !m.absolute
 	}
 
 	return m, nil
 }
 
+// nextRepoHeader returns the index of the nearest repo header after (dir 1) or
+// before (dir -1) from, or from itself if there is none in that direction.
+func nextRepoHeader(items []flatItem, from, dir int) int {
+	for i := from + dir; i >= 0 && i < len(items); i += dir {
+		if items[i].isRepo {
+			return i
+		}
+	}
+	return from
+}
+
+// moveCursorToFile puts the cursor on the file's row within its repo group,
+// keeping the cursor in bounds if that row isn't visible.
+func (m *FileTreeModel) moveCursorToFile(repoIndex, fileIndex int) {
+	for i, item := range m.visibleItems() {
+		if !item.isRepo && !item.pinned && item.repoIndex == repoIndex && item.fileIndex == fileIndex {
+			m.cursor = i
+			return
+		}
+	}
+	m.clampCursor()
+}
+
+// moveCursorToSelected puts the cursor on the selected file's row, if it is visible.
+func (m *FileTreeModel) moveCursorToSelected() {
+	if m.selected == nil {
+		m.clampCursor()
+		return
+	}
+	for ri, rg := range m.repos {
+		if rg.Repo.WatchPath != m.selected.Repo.WatchPath {
+			continue
+		}
+		for fi, f := range m.filteredFiles(ri) {
+			if f.Path == m.selected.Path {
+				m.moveCursorToFile(ri, fi)
+				return
+			}
+		}
+	}
+	m.clampCursor()
+}
+
+// SelectFile selects the file at path in the repo watched at watchPath and
+// returns the command that loads its diff, or nil if the tree doesn't list it.
+func (m *FileTreeModel) SelectFile(watchPath, path string) tea.Cmd {
+	for ri, rg := range m.repos {
+		if rg.Repo.WatchPath != watchPath {
+			continue
+		}
+		for _, f := range m.filteredFiles(ri) {
+			if f.Path == path {
+				file := f
+				m.selected = &file
+				m.moveCursorToSelected()
+				return func() tea.Msg {
+					return FileSelectedMsg{File: file}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// maxCount caps numeric prefixes so runaway digit input can't overflow.
+const maxCount = 99999
+
+// countDigit reports whether msg extends a pending numeric prefix and returns
+// its value. A leading 0 is not a count.
+func countDigit(msg tea.KeyMsg, pending int) (int, bool) {
+	key := msg.String()
+	if len(key) != 1 || key[0] < '0' || key[0] > '9' {
+		return 0, false
+	}
+	if key == "0" && pending == 0 {
+		return 0, false
+	}
+	if pending*10 > maxCount {
+		return 0, false
+	}
+	return int(key[0] - '0'), true
+}
+
 // selectFileAtCursor returns a command to load the diff for the file at the current cursor position.
 // Returns nil if the cursor is on a repo header or the file is already selected.
 func (m *FileTreeModel) selectFileAtCursor() tea.Cmd {
@@ -198,37 +491,82 @@ func (m *FileTreeModel) selectFileAtCursor() tea.Cmd {
 
 // handleFilesChanged updates the tree with new file data for a repo.
 func (m FileTreeModel) handleFilesChanged(msg FilesChangedMsg) (FileTreeModel, tea.Cmd) {
+	var expire tea.Cmd
 	found := false
 	for i, rg := range m.repos {
 		if rg.Repo.WatchPath == msg.Repo.WatchPath {
+			// Nothing to do if the repo looks exactly as it did; avoids needless re-renders
+			if msg.Err != nil {
+				// Keep the last known files; the header shows the error
+				m.repos[i].Err = msg.Err
+				return m, nil
+			}
+			if rg.Err == nil && rg.State == msg.State && rg.Ahead == msg.Ahead && rg.Behind == msg.Behind &&
+				sameFiles(rg.Files, msg.Files) {
+				return m, nil
+			}
+			m.repos[i].Err = nil
+			expire = m.markFresh(rg.Files, msg.Files)
+			if key := rg.Repo.WatchPath; !m.manual[key] {
+				m.collapsed[key] = autoCollapsed(rg, msg.Files, m.collapsed[key])
+			}
 			m.repos[i].Files = msg.Files
+			m.repos[i].State = msg.State
+			m.repos[i].Ahead, m.repos[i].Behind = msg.Ahead, msg.Behind
 			found = true
 			break
 		}
 	}
-	if !found && len(msg.Files) > 0 {
+	// Clean repos are kept so they can be shown on demand; visibleItems hides them otherwise
+	if !found {
 		m.repos = append(m.repos, RepoGroup{
-			Repo:  msg.Repo,
-			Files: msg.Files,
+			Repo:   msg.Repo,
+			Files:  msg.Files,
+			State:  msg.State,
+			Err:    msg.Err,
+			Ahead:  msg.Ahead,
+			Behind: msg.Behind,
 		})
+		if key := msg.Repo.WatchPath; !m.manual[key] {
+			m.collapsed[key] = len(msg.Files) == 0
+		}
 	}
 
-	// Prune repos with no remaining files
-	kept := m.repos[:0]
-	for _, rg := range m.repos {
-		if len(rg.Files) > 0 {
-			kept = append(kept, rg)
+	// Drop pins and highlights for files in this repo that no longer have changes
+	current := make(map[string]bool, len(msg.Files))
+	for _, f := range msg.Files {
+		current[fileKey(f)] = true
+	}
+	prefix := msg.Repo.WatchPath + "\x00"
+	for key := range m.pinned {
+		if strings.HasPrefix(key, prefix) && !current[key] {
+			delete(m.pinned, key)
+		}
+	}
+	for key := range m.fresh {
+		if strings.HasPrefix(key, prefix) && !current[key] {
+			delete(m.fresh, key)
 		}
 	}
-	m.repos = kept
 
-	// Clear selection if the selected file is no longer in the changed set
+	// Clear selection if the selected file is no longer in the changed set,
+	// and reload its diff only if its status, mode or content changed
+	var reload tea.Cmd
 	if m.selected != nil {
 		stillExists := false
 		for _, rg := range m.repos {
 			for _, f := range rg.Files {
 				if f.Repo.WatchPath == m.selected.Repo.WatchPath && f.Path == m.selected.Path {
 					stillExists = true
+					if f.Status != m.selected.Status || f.Mode != m.selected.Mode ||
+						f.Added != m.selected.Added || f.Removed != m.selected.Removed ||
+						f.DiffHash != m.selected.DiffHash {
+						file := f
+						m.selected = &file
+						reload = func() tea.Msg {
+							return FileSelectedMsg{File: file}
+						}
+					}
 					break
 				}
 			}
@@ -243,24 +581,225 @@ func (m FileTreeModel) handleFilesChanged(msg FilesChangedMsg) (FileTreeModel, t
 
 	m.clampCursor()
 
-	// Auto-select first file if nothing is selected
-	if m.selected == nil {
-		items := m.visibleItems()
-		for _, item := range items {
-			if !item.isRepo {
-				files := m.filteredFiles(item.repoIndex)
-				if item.fileIndex < len(files) {
-					file := files[item.fileIndex]
-					m.selected = &file
-					return m, func() tea.Msg {
-						return FileSelectedMsg{File: file}
-					}
+	if selected := m.autoSelectFirst(); selected != nil {
+		return m, tea.Batch(expire, selected)
+	}
+	return m, tea.Batch(reload, expire)
+}
+
+// autoSelectFirst selects the first visible file if nothing is selected and
+// the auto-select mode allows it now, returning the command announcing it, or
+// nil if no file was selected.
+func (m *FileTreeModel) autoSelectFirst() tea.Cmd {
+	if m.selected != nil || !m.shouldAutoSelect() {
+		return nil
+	}
+	for _, item := range m.visibleItems() {
+		if !item.isRepo {
+			files := m.filteredFiles(item.repoIndex)
+			if item.fileIndex < len(files) {
+				file := files[item.fileIndex]
+				m.selected = &file
+				return func() tea.Msg {
+					return FileSelectedMsg{File: file}
 				}
 			}
 		}
 	}
+	return nil
+}
 
-	return m, nil
+// markFresh highlights the files in files that weren't in prev, a repo's
+// previous report, and returns the command that clears them after freshTime,
+// or nil if none are new.
+func (m *FileTreeModel) markFresh(prev, files []diffwatch.ChangedFile) tea.Cmd {
+	known := make(map[string]bool, len(prev))
+	for _, f := range prev {
+		known[f.Path] = true
+	}
+	now := time.Now()
+	marked := false
+	for _, f := range files {
+		if !known[f.Path] {
+			m.fresh[fileKey(f)] = now
+			marked = true
+		}
+	}
+	if !marked {
+		return nil
+	}
+	return tea.Tick(freshTime, func(time.Time) tea.Msg {
+		return freshExpiredMsg{}
+	})
+}
+
+// shouldAutoSelect reports whether the auto-select mode allows selecting a file now.
+func (m *FileTreeModel) shouldAutoSelect() bool {
+	switch m.autoSelect {
+	case AutoSelectOff:
+		return false
+	case AutoSelectIdle:
+		return time.Since(m.lastInput) >= autoSelectIdleTime
+	default:
+		return true
+	}
+}
+
+// sameFiles reports whether two changed-file lists have the same paths,
+// statuses and line counts.
+func sameFiles(a, b []diffwatch.ChangedFile) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Path != b[i].Path || a[i].Status != b[i].Status || a[i].XY != b[i].XY || a[i].Mode != b[i].Mode || a[i].DiffHash != b[i].DiffHash {
+			return false
+		}
+		if a[i].Added != b[i].Added || a[i].Removed != b[i].Removed {
+			return false
+		}
+	}
+	return true
+}
+
+// CurrentRepo returns the repo of the row under the cursor, or nil if the tree is empty.
+func (m *FileTreeModel) CurrentRepo() *diffwatch.Repo {
+	items := m.visibleItems()
+	if m.cursor >= len(items) {
+		return nil
+	}
+	return m.repos[items[m.cursor].repoIndex].Repo
+}
+
+// RetainRepos drops the groups, pins and selection of repos not in repos, and
+// points the remaining groups at the new Repo values.
+func (m *FileTreeModel) RetainRepos(repos []diffwatch.Repo) {
+	byPath := make(map[string]*diffwatch.Repo, len(repos))
+	for i := range repos {
+		byPath[repos[i].WatchPath] = &repos[i]
+	}
+	kept := m.repos[:0]
+	for _, rg := range m.repos {
+		if repo, ok := byPath[rg.Repo.WatchPath]; ok {
+			rg.Repo = repo
+			kept = append(kept, rg)
+		}
+	}
+	m.repos = kept
+	for key := range m.pinned {
+		watchPath, _, _ := strings.Cut(key, "\x00")
+		if byPath[watchPath] == nil {
+			delete(m.pinned, key)
+		}
+	}
+	if m.selected != nil && byPath[m.selected.Repo.WatchPath] == nil {
+		m.selected = nil
+	}
+	m.clampCursor()
+}
+
+// AdjacentFiles returns the files in the rows nearest the cursor above and
+// below it, skipping repo headers, for prefetching their diffs.
+func (m *FileTreeModel) AdjacentFiles() []diffwatch.ChangedFile {
+	items := m.visibleItems()
+	var files []diffwatch.ChangedFile
+	for _, dir := range []int{1, -1} {
+		for i := m.cursor + dir; i >= 0 && i < len(items); i += dir {
+			if items[i].isRepo {
+				continue
+			}
+			if repoFiles := m.filteredFiles(items[i].repoIndex); items[i].fileIndex < len(repoFiles) {
+				files = append(files, repoFiles[items[i].fileIndex])
+			}
+			break
+		}
+	}
+	return files
+}
+
+// SelectedBreadcrumb returns "repo: path" for the selected file when it's
+// hidden in a collapsed repo, or "" otherwise.
+func (m *FileTreeModel) SelectedBreadcrumb() string {
+	if m.selected == nil || m.flat || !m.collapsed[m.selected.Repo.WatchPath] {
+		return ""
+	}
+	return m.selected.Repo.Name + ": " + m.selected.Path
+}
+
+// CursorRepoErr returns the repo under the cursor and the error from its last
+// scan, if that scan failed.
+func (m *FileTreeModel) CursorRepoErr() (*diffwatch.Repo, error) {
+	items := m.visibleItems()
+	if m.cursor >= len(items) {
+		return nil, nil
+	}
+	rg := m.repos[items[m.cursor].repoIndex]
+	return rg.Repo, rg.Err
+}
+
+// Changes reports whether msg changes the files or state of a repo already
+// in the tree. A repo's first report isn't a change.
+func (m *FileTreeModel) Changes(msg FilesChangedMsg) bool {
+	for _, rg := range m.repos {
+		if rg.Repo.WatchPath == msg.Repo.WatchPath {
+			return rg.State != msg.State || !sameFiles(rg.Files, msg.Files)
+		}
+	}
+	return false
+}
+
+// NewFiles counts the files in msg that weren't listed for its repo before.
+// A repo's first report counts as nothing new.
+func (m *FileTreeModel) NewFiles(msg FilesChangedMsg) int {
+	for _, rg := range m.repos {
+		if rg.Repo.WatchPath != msg.Repo.WatchPath {
+			continue
+		}
+		known := make(map[string]bool, len(rg.Files))
+		for _, f := range rg.Files {
+			known[f.Path] = true
+		}
+		n := 0
+		for _, f := range msg.Files {
+			if !known[f.Path] {
+				n++
+			}
+		}
+		return n
+	}
+	return 0
+}
+
+// ToggleShowClean switches between hiding and showing repos without changes.
+func (m *FileTreeModel) ToggleShowClean() {
+	m.showClean = !m.showClean
+	m.clampCursor()
+}
+
+// toggleCollapsed collapses or expands a repo group at the user's request.
+func (m *FileTreeModel) toggleCollapsed(repoIndex int) {
+	key := m.repos[repoIndex].Repo.WatchPath
+	m.collapsed[key] = !m.collapsed[key]
+	m.manual[key] = true
+}
+
+// autoCollapsed returns the collapsed state for a repo group receiving files,
+// given its current state: collapsed once it becomes clean, expanded when a
+// file it didn't have appears.
+func autoCollapsed(rg RepoGroup, files []diffwatch.ChangedFile, collapsed bool) bool {
+	if len(files) == 0 {
+		return true
+	}
+	prev := make(map[string]bool, len(rg.Files))
+	for _, f := range rg.Files {
+		prev[f.Path] = true
+	}
+	for _, f := range files {
+		if !prev[f.Path] {
+			return false
+		}
+	}
+	return collapsed
 }
 
 // clampCursor ensures cursor stays within bounds.
@@ -274,6 +813,71 @@ func (m *FileTreeModel) clampCursor() {
 	}
 }
 
+// pageRows returns how many items fit in the panel at once.
+func (m *FileTreeModel) pageRows() int {
+	if m.height <= 0 {
+		return 50
+	}
+	if m.legend {
+		return max(m.height-len(m.legendLines()), 1)
+	}
+	return m.height
+}
+
+// legendEntries pairs each status letter and staging mark with its meaning.
+var legendEntries = []struct{ glyph, meaning string }{
+	{"M", "modified"},
+	{"A", "added"},
+	{"D", "deleted"},
+	{"R", "renamed"},
+	{"C", "copied"},
+	{"?", "untracked"},
+	{"!", "ignored"},
+	{"U", "conflict"},
+	{"●", "staged"},
+	{"◐", "partly staged"},
+}
+
+// legendLines renders the status legend, wrapped to the panel width, with
+// each letter in the color the tree draws it in.
+func (m *FileTreeModel) legendLines() []string {
+	faint := lipgloss.NewStyle().Faint(true)
+	var lines []string
+	var line string
+	lineWidth := 0
+	for _, e := range legendEntries {
+		style := m.theme.StatusStyle(e.glyph)
+		switch e.glyph {
+		case "●":
+			style = lipgloss.NewStyle().Foreground(m.theme.Added)
+		case "◐":
+			style = lipgloss.NewStyle().Foreground(m.theme.Modified)
+		}
+		w := ansi.StringWidth(e.glyph + " " + e.meaning)
+		if lineWidth > 0 && m.width > 0 && lineWidth+2+w > m.width {
+			lines = append(lines, line)
+			line, lineWidth = "", 0
+		}
+		if lineWidth > 0 {
+			line += "  "
+			lineWidth += 2
+		}
+		line += style.Render(e.glyph) + faint.Render(" "+e.meaning)
+		lineWidth += w
+	}
+	return append(lines, line)
+}
+
+// scrollOffset returns the first item to draw: the current offset, moved as
+// little as needed to keep the cursor on screen.
+func (m *FileTreeModel) scrollOffset() int {
+	offset := min(m.offset, m.cursor)
+	if rows := m.pageRows(); m.cursor >= offset+rows {
+		offset = m.cursor - rows + 1
+	}
+	return max(offset, 0)
+}
+
 // SetSize sets the available width and height for rendering.
 func (m *FileTreeModel) SetSize(w, h int) {
 	m.width = w
@@ -284,15 +888,13 @@ func (m *FileTreeModel) SetSize(w, h int) {
 func (m FileTreeModel) View() string {
 	items := m.visibleItems()
 
-	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(m.theme.Accent)
+	faintStyle := lipgloss.NewStyle().Faint(true)
+	pinStyle := lipgloss.NewStyle().Foreground(m.theme.Warning)
+	stateStyle := lipgloss.NewStyle().Bold(true).Foreground(m.theme.State)
+	errorStyle := lipgloss.NewStyle().Foreground(m.theme.Error)
 	selectedStyle := lipgloss.NewStyle().Reverse(true)
-	statusColors := map[string]lipgloss.Style{
-		"M": lipgloss.NewStyle().Foreground(lipgloss.Color("3")),  // yellow
-		"A": lipgloss.NewStyle().Foreground(lipgloss.Color("2")),  // green
-		"D": lipgloss.NewStyle().Foreground(lipgloss.Color("1")),  // red
-		"R": lipgloss.NewStyle().Foreground(lipgloss.Color("6")),  // cyan
-		"?": lipgloss.NewStyle().Foreground(lipgloss.Color("8")),  // gray
-	}
+	freshStyle := lipgloss.NewStyle().Background(m.theme.Fresh)
 
 	if len(items) == 0 {
 		msg := "No uncommitted changes found.\nWatching for changes..."
@@ -305,17 +907,11 @@ func (m FileTreeModel) View() string {
 			Render(msg)
 	}
 
-	var lines []string
-	maxLines := m.height
-	if maxLines <= 0 {
-		maxLines = 50
-	}
+	same := m.sameChanges()
 
-	// Calculate scroll offset to keep cursor visible
-	scrollOffset := 0
-	if m.cursor >= maxLines {
-		scrollOffset = m.cursor - maxLines + 1
-	}
+	var lines []string
+	maxLines := m.pageRows()
+	scrollOffset := m.scrollOffset()
 
 	for i, item := range items {
 		if i < scrollOffset {
@@ -326,26 +922,84 @@ func (m FileTreeModel) View() string {
 		}
 
 		var line string
+		var file *diffwatch.ChangedFile // the row's file, nil for a repo header
+		var state string
+		if item.isRepo && m.repos[item.repoIndex].State != "" {
+			state = " [" + m.repos[item.repoIndex].State + "]"
+		}
+		var sync string
 		if item.isRepo {
+			sync = syncNote(m.repos[item.repoIndex])
+		}
+		if item.isRepo && m.repos[item.repoIndex].Err != nil && len(m.repos[item.repoIndex].Files) == 0 {
+			suffix := " (error)"
+			name := m.fitLeft(m.repos[item.repoIndex].Repo.Name, 2+len(suffix))
+			line = "  " + name + errorStyle.Render(suffix)
+		} else if item.isRepo && len(m.repos[item.repoIndex].Files) == 0 {
+			suffix := " (clean)"
+			name := m.fitLeft(m.repos[item.repoIndex].Repo.Name, 2+len(suffix)+len(state)+ansi.StringWidth(sync))
+			line = faintStyle.Render("  " + name + suffix)
+		} else if item.isRepo {
 			rg := m.repos[item.repoIndex]
 			arrow := "▾"
-			if rg.Collapsed {
+			if m.collapsed[rg.Repo.WatchPath] {
 				arrow = "▸"
 			}
-			fileCount := len(m.filteredFiles(item.repoIndex))
-			line = headerStyle.Render(fmt.Sprintf("%s %s (%d)", arrow, rg.Repo.Name, fileCount))
+			suffix := fmt.Sprintf(" (%d)", len(m.filteredFiles(item.repoIndex)))
+			var errNote string
+			if rg.Err != nil {
+				errNote = " (error)"
+			}
+			name := m.fitLeft(rg.Repo.Name, 2+len(suffix)+len(errNote)+len(state)+ansi.StringWidth(sync))
+			// Mark the selected file's repo so it stays findable when collapsed or scrolled
+			sep := " "
+			style := headerStyle
+			if m.selected != nil && m.selected.Repo.WatchPath == rg.Repo.WatchPath {
+				sep = "▎"
+				style = style.Underline(true)
+			}
+			line = style.Render(arrow+sep+name+suffix) + errorStyle.Render(errNote)
 		} else {
 			files := m.filteredFiles(item.repoIndex)
 			if item.fileIndex < len(files) {
 				f := files[item.fileIndex]
-				statusStyle, ok := statusColors[f.Status]
-				if !ok {
-					statusStyle = lipgloss.NewStyle()
+				file = &f
+				glyph := m.theme.StatusStyle(f.Status).Render(f.Status) + m.stagingMark(f)
+				// Mode-only changes would otherwise look like ordinary edits
+				var note string
+				if f.ModeOnly {
+					note = " (mode)"
+				}
+				if f.LFS {
+					note += " (lfs)"
+				}
+				if n := same[sameChangeKey(f)]; n > 1 {
+					note += fmt.Sprintf(" (same in %d repos)", n)
+				}
+				if item.pinned {
+					path := m.fitLeft(m.displayPath(f), 5+len(note)+1+ansi.StringWidth(f.Repo.Name))
+					line = fmt.Sprintf("%s %s %s%s %s", pinStyle.Render("★"), glyph, path,
+						faintStyle.Render(note), faintStyle.Render(f.Repo.Name))
+				} else if m.flat {
+					// An absolute path already says which repo the file is in
+					label := f.Repo.Name + ": " + f.Path
+					if m.absolute {
+						label = m.displayPath(f)
+					}
+					line = fmt.Sprintf("  %s %s%s", glyph, m.fitLeft(label, 5+len(note)), faintStyle.Render(note))
+				} else {
+					line = fmt.Sprintf("  %s %s%s", glyph, m.fitLeft(m.displayPath(f), 5+len(note)), faintStyle.Render(note))
 				}
-				line = fmt.Sprintf("  %s %s", statusStyle.Render(f.Status), f.Path)
 			}
 		}
 
+		if state != "" {
+			line += stateStyle.Render(state)
+		}
+		if sync != "" {
+			line += faintStyle.Render(sync)
+		}
+
 		// Hard truncate to panel width (preserving ANSI sequences)
 		if m.width > 0 {
 			line = truncateAnsi(line, m.width)
@@ -353,68 +1007,133 @@ func (m FileTreeModel) View() string {
 
 		if i == m.cursor {
 			line = selectedStyle.Render(line)
+		} else if file != nil && m.isFresh(*file) {
+			// Like the cursor, the flash drops the row's own colors so it reads the same on any row
+			line = freshStyle.Render(ansi.Strip(line))
 		}
 
 		lines = append(lines, line)
 	}
 
+	if m.legend {
+		// Keep the legend at the bottom of the panel however few rows there are
+		for len(lines) < maxLines {
+			lines = append(lines, "")
+		}
+		lines = append(lines, m.legendLines()...)
+	}
 	result := strings.Join(lines, "\n")
 
 	// Show filter bar at bottom
 	if m.filtering {
 		filterBar := fmt.Sprintf("/%s█", m.filter)
-		result += "\n" + lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render(filterBar)
+		result += "\n" + lipgloss.NewStyle().Foreground(m.theme.Warning).Render(filterBar)
 	}
 
 	return result
 }
 
-// truncateAnsi truncates a string containing ANSI escape sequences to maxWidth
-// visible characters. ANSI sequences are passed through without counting toward width.
-func truncateAnsi(s string, maxWidth int) string {
-	var out strings.Builder
-	visible := 0
-	i := 0
-	for i < len(s) && visible < maxWidth {
-		if s[i] == '\x1b' {
-			// Pass through entire ANSI escape sequence
-			j := i + 1
-			if j < len(s) && s[j] == '[' {
-				j++
-				for j < len(s) && s[j] != 'm' {
-					j++
-				}
-				if j < len(s) {
-					j++ // include the 'm'
-				}
-			}
-			out.WriteString(s[i:j])
-			i = j
-		} else {
-			_, size := utf8.DecodeRuneInString(s[i:])
-			out.WriteString(s[i : i+size])
-			visible++
-			i += size
-		}
-	}
-	// Append any trailing ANSI reset sequences so colors don't bleed
-	for i < len(s) {
-		if s[i] == '\x1b' {
-			j := i + 1
-			if j < len(s) && s[j] == '[' {
-				j++
-				for j < len(s) && s[j] != 'm' {
-					j++
-				}
-				if j < len(s) {
-					j++
-				}
+// displayPath returns the path shown for f in the tree: relative to its repo,
+// or absolute when absolute paths are toggled on.
+func (m FileTreeModel) displayPath(f diffwatch.ChangedFile) string {
+	if m.absolute {
+		return filepath.Join(f.Repo.Path, f.Path)
+	}
+	return f.Path
+}
+
+// isFresh reports whether f's row is highlighted as just appeared.
+func (m FileTreeModel) isFresh(f diffwatch.ChangedFile) bool {
+	at, ok := m.fresh[fileKey(f)]
+	return ok && time.Since(at) < freshTime
+}
+
+// sameChanges counts, for each change made to a path, how many repos made it,
+// keyed by sameChangeKey. Files without a DiffHash aren't counted.
+func (m FileTreeModel) sameChanges() map[string]int {
+	counts := make(map[string]int)
+	for _, rg := range m.repos {
+		for _, f := range rg.Files {
+			if f.DiffHash != "" {
+				counts[sameChangeKey(f)]++
 			}
-			out.WriteString(s[i:j])
-			i = j
-		} else {
-			break
 		}
 	}
-	return out.String()
+	return counts
+}
+
+// sameChangeKey identifies a file's change across repos: its path and DiffHash.
+func sameChangeKey(f diffwatch.ChangedFile) string {
+	return f.Path + "\x00" + f.DiffHash
+}
+
+// stagingMark returns the column after a file's status glyph: "●" when all of
+// its changes are staged, "◐" when only some are, and a blank otherwise.
+func (m FileTreeModel) stagingMark(f diffwatch.ChangedFile) string {
+	switch {
+	case f.Staged() && f.Unstaged():
+		return lipgloss.NewStyle().Foreground(m.theme.Modified).Render("◐")
+	case f.Staged():
+		return lipgloss.NewStyle().Foreground(m.theme.Added).Render("●")
+	}
+	return " "
+}
+
+// syncNote describes how far rg's branch is ahead of and behind its upstream,
+// e.g. " ↑2 ↓1", or "" when it's in sync or has no upstream.
+func syncNote(rg RepoGroup) string {
+	var note string
+	if rg.Ahead > 0 {
+		note += fmt.Sprintf(" ↑%d", rg.Ahead)
+	}
+	if rg.Behind > 0 {
+		note += fmt.Sprintf(" ↓%d", rg.Behind)
+	}
+	return note
+}
+
+// fitLeft shortens a path or name so it fits in the panel alongside reserved
+// columns of other content, dropping leading characters so the basename stays visible.
+func (m FileTreeModel) fitLeft(s string, reserved int) string {
+	if m.width <= 0 {
+		return s
+	}
+	return truncateLeft(s, m.width-reserved)
+}
+
+// truncateLeft shortens plain text s to maxWidth cells by replacing its start
+// with "…", preferring to cut at a "/" so the result reads "…/dir/file.go".
+// Wide characters and grapheme clusters are never split.
+func truncateLeft(s string, maxWidth int) string {
+	width := ansi.StringWidth(s)
+	if width <= maxWidth {
+		return s
+	}
+	if maxWidth <= 1 {
+		return keepRight(s, max(maxWidth, 0))
+	}
+	tail := keepRight(s, maxWidth-1)
+	if i := strings.Index(tail, "/"); i > 0 {
+		tail = tail[i:]
+	}
+	return "…" + tail
+}
+
+// keepRight returns the end of s that fits in width cells. ansi.TruncateLeft
+// keeps a wide character the cut falls inside, which would leave the result a
+// cell too wide, so the cut moves left until it fits.
+func keepRight(s string, width int) string {
+	for n := ansi.StringWidth(s) - width; ; n++ {
+		if tail := ansi.TruncateLeft(s, n, ""); ansi.StringWidth(tail) <= width {
+			return tail
+		}
+	}
+}
+
+// truncateAnsi truncates a string containing ANSI escape sequences to maxWidth
+// display cells, cutting on grapheme boundaries. ANSI sequences are passed
+// through without counting toward width, including any after the cut point, so
+// trailing resets still apply and colors don't bleed.
+func truncateAnsi(s string, maxWidth int) string {
+	return ansi.Truncate(s, maxWidth, "")
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
+)
+
+// newTestTree returns a file tree with default keys and the dark theme.
+func newTestTree(t *testing.T) FileTreeModel {
+	t.Helper()
+	keys, err := NewKeyMap(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFileTreeModel(false, AutoSelectOff, keys, NewTheme(ThemeDark))
+}
+
+// TestCollapseSurvivesOtherRepoChange collapses one repo and checks a change
+// in another doesn't expand it again.
+func TestCollapseSurvivesOtherRepoChange(t *testing.T) {
+	m := newTestTree(t)
+	a := &diffwatch.Repo{Name: "a", Path: "/a", WatchPath: "/a"}
+	b := &diffwatch.Repo{Name: "b", Path: "/b", WatchPath: "/b"}
+	files := func(repo *diffwatch.Repo, paths ...string) []diffwatch.ChangedFile {
+		var fs []diffwatch.ChangedFile
+		for _, p := range paths {
+			fs = append(fs, diffwatch.ChangedFile{Repo: repo, Path: p, Status: "M", XY: " M"})
+		}
+		return fs
+	}
+	m, _ = m.Update(FilesChangedMsg{Repo: a, Files: files(a, "x")})
+	m, _ = m.Update(FilesChangedMsg{Repo: b, Files: files(b, "y")})
+
+	for i, rg := range m.repos {
+		if rg.Repo.WatchPath == b.WatchPath {
+			m.toggleCollapsed(i)
+		}
+	}
+	if !m.collapsed[b.WatchPath] {
+		t.Fatal("b didn't collapse")
+	}
+
+	// A new file in repo a expands it, and must leave b as the user set it
+	m, _ = m.Update(FilesChangedMsg{Repo: a, Files: files(a, "x", "z")})
+	if !m.collapsed[b.WatchPath] {
+		t.Error("b expanded after a change in a")
+	}
+	if m.collapsed[a.WatchPath] {
+		t.Error("a is collapsed after a new file appeared in it")
+	}
+}
+
+// TestTruncate checks the truncation helpers never exceed the width, don't
+// split wide characters, and keep escape sequences, so a color cut short is
+// still reset.
+func TestTruncate(t *testing.T) {
+	const red, reset = "\x1b[31m", "\x1b[0m"
+	tests := []struct {
+		name     string
+		truncate func(string, int) string
+		in       string
+		width    int
+		want     string
+	}{
+		{"left fits", truncateLeft, "src/main.go", 20, "src/main.go"},
+		{"left at slash", truncateLeft, "src/deep/dir/main.go", 14, "…/dir/main.go"},
+		{"left wide", truncateLeft, "日本語/ファイル.go", 9, "…イル.go"},
+		{"left one cell", truncateLeft, "日本語", 1, ""},
+		{"ansi plain", truncateAnsi, "abcdef", 3, "abc"},
+		{"ansi wide", truncateAnsi, "日本語", 5, "日本"},
+		{"ansi keeps reset", truncateAnsi, red + "abcdef" + reset, 3, red + "abc" + reset},
+		{"ansi emoji", truncateAnsi, "👍🏽ok", 2, "👍🏽"},
+		{"to width lines", truncateToWidth, red + "abcdef" + reset + "\nxy", 4, red + "abcd" + reset + "\nxy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.truncate(tt.in, tt.width)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			for _, line := range strings.Split(got, "\n") {
+				if w := ansi.StringWidth(line); w > tt.width {
+					t.Errorf("%q is %d cells wide, over %d", line, w, tt.width)
+				}
+			}
+		})
+	}
+}
+
+// TestAutoSelectIdleTick checks AutoSelectIdle selects a file once typing
+// stops, with no further change coming in to trigger it.
+func TestAutoSelectIdleTick(t *testing.T) {
+	keys, err := NewKeyMap(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewFileTreeModel(false, AutoSelectIdle, keys, NewTheme(ThemeDark))
+	repo := &diffwatch.Repo{Name: "r", Path: "/r", WatchPath: "/r"}
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	m, _ = m.Update(FilesChangedMsg{Repo: repo, Files: []diffwatch.ChangedFile{{Repo: repo, Path: "f", Status: "M", XY: " M"}}})
+	if m.selected != nil {
+		t.Fatal("selected a file while the user was typing")
+	}
+
+	// The tick arrives once the idle time has passed since the key press
+	m.lastInput = time.Now().Add(-autoSelectIdleTime)
+	m, cmd := m.Update(autoSelectIdleMsg{})
+	if m.selected == nil || m.selected.Path != "f" || cmd == nil {
+		t.Fatalf("selected %v after the idle tick, want f", m.selected)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Action names a command that keys can be bound to in the config file.
+type Action string
+
+const (
+	// Global actions, handled by the root model.
+	ActionQuit             Action = "quit"
+	ActionSwitchPanel      Action = "switch-panel"
+	ActionRefresh          Action = "refresh"
+	ActionReveal           Action = "reveal"
+	ActionMoreContext      Action = "more-context"
+	ActionLessContext      Action = "less-context"
+	ActionToggleWhitespace Action = "toggle-whitespace"
+	ActionToggleClean      Action = "toggle-clean"
+	ActionPause            Action = "pause"
+	ActionExportPatch      Action = "export-patch"
+	ActionExportStaged     Action = "export-staged-patch"
+	ActionOpenPager        Action = "open-pager"
+	ActionOpenEditor       Action = "open-editor"
+	ActionToggleIgnored    Action = "toggle-ignored"
+	ActionZoom             Action = "zoom"
+	ActionReloadConfig     Action = "reload-config"
+	ActionSwitchProfile    Action = "switch-profile"
+	ActionToggleLegend     Action = "toggle-legend"
+
+	// Navigation shared by both panels.
+	ActionDown   Action = "navigate-down"
+	ActionUp     Action = "navigate-up"
+	ActionTop    Action = "top"
+	ActionBottom Action = "bottom"
+
+	ActionPageDown Action = "page-down"
+	ActionPageUp   Action = "page-up"
+
+	// File tree actions.
+	ActionNextRepo       Action = "next-repo"
+	ActionPrevRepo       Action = "prev-repo"
+	ActionSelect         Action = "select"
+	ActionToggleCollapse Action = "toggle-collapse"
+	ActionPin            Action = "pin"
+	ActionFilter         Action = "filter"
+	ActionToggleFlat     Action = "toggle-flat"
+	ActionHideUntracked  Action = "hide-untracked"
+	ActionToggleAbsolute Action = "toggle-absolute-paths"
+
+	// Diff view actions.
+	ActionHalfPageDown Action = "half-page-down"
+	ActionHalfPageUp   Action = "half-page-up"
+	ActionNextHunk     Action = "next-hunk"
+	ActionPrevHunk     Action = "prev-hunk"
+	ActionStageHunk    Action = "stage-hunk"
+	ActionCopyLine     Action = "copy-line"
+	ActionFilterLines  Action = "filter-lines"
+)
+
+// defaultKeys are the bindings used for any action the config doesn't rebind.
+var defaultKeys = map[Action][]string{
+	ActionQuit:             {"q", "ctrl+c"},
+	ActionSwitchPanel:      {"tab"},
+	ActionRefresh:          {"r"},
+	ActionReveal:           {"o"},
+	ActionMoreContext:      {"+", "="},
+	ActionLessContext:      {"-"},
+	ActionToggleWhitespace: {"w"},
+	ActionToggleClean:      {"C"},
+	ActionPause:            {"P", " "},
+	ActionExportPatch:      {"E"},
+	ActionExportStaged:     {"alt+e"},
+	ActionOpenPager:        {"L"},
+	ActionOpenEditor:       {"e"},
+	ActionToggleIgnored:    {"I"},
+	ActionZoom:             {"z"},
+	ActionReloadConfig:     {"R"},
+	ActionSwitchProfile:    {"S"},
+	ActionToggleLegend:     {"?"},
+	ActionDown:             {"j", "down"},
+	ActionUp:               {"k", "up"},
+	ActionTop:              {"g", "home"},
+	ActionBottom:           {"G", "end"},
+	ActionPageDown:         {"pgdown", "ctrl+f"},
+	ActionPageUp:           {"pgup", "ctrl+b"},
+	ActionNextRepo:         {"}", "J"},
+	ActionPrevRepo:         {"{", "K"},
+	ActionSelect:           {"enter"},
+	ActionToggleCollapse:   {"c"},
+	ActionPin:              {"p"},
+	ActionFilter:           {"/"},
+	ActionToggleFlat:       {"f"},
+	ActionHideUntracked:    {"U"},
+	ActionToggleAbsolute:   {"A"},
+	ActionHalfPageDown:     {"d", "ctrl+d"},
+	ActionHalfPageUp:       {"u", "ctrl+u"},
+	ActionNextHunk:         {"n"},
+	ActionPrevHunk:         {"N"},
+	ActionStageHunk:        {"s"},
+	ActionCopyLine:         {"y"},
+	ActionFilterLines:      {"a"},
+}
+
+// KeyMap resolves key presses to actions.
+type KeyMap struct {
+	actions map[string]Action   // key string -> action
+	keys    map[Action][]string // action -> bound keys, for help text
+}
+
+// NewKeyMap builds a KeyMap from the defaults with overrides applied. Each
+// override replaces all default keys for its action, and a key bound by an
+// override takes precedence over the same key's default action. Unknown
+// action names are reported in the returned error; the rest still apply.
+func NewKeyMap(overrides map[string][]string) (KeyMap, error) {
+	km := KeyMap{
+		actions: make(map[string]Action),
+		keys:    make(map[Action][]string),
+	}
+	for action, keys := range defaultKeys {
+		if _, ok := overrides[string(action)]; ok {
+			continue
+		}
+		km.bind(action, keys)
+	}
+
+	var unknown []string
+	for name, keys := range overrides {
+		action := Action(name)
+		if _, ok := defaultKeys[action]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		km.bind(action, keys)
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return km, fmt.Errorf("unknown key binding action(s): %s", strings.Join(unknown, ", "))
+	}
+	return km, nil
+}
+
+// bind assigns keys to action, taking each key away from any action it was bound to.
+func (k *KeyMap) bind(action Action, keys []string) {
+	for _, key := range keys {
+		if prev, ok := k.actions[key]; ok && prev != action {
+			k.keys[prev] = removeKey(k.keys[prev], key)
+		}
+		k.actions[key] = action
+	}
+	k.keys[action] = append(k.keys[action], keys...)
+}
+
+// removeKey returns keys without key.
+func removeKey(keys []string, key string) []string {
+	var kept []string
+	for _, k := range keys {
+		if k != key {
+			kept = append(kept, k)
+		}
+	}
+	return kept
+}
+
+// Action returns the action bound to msg, or "" if the key is unbound.
+func (k KeyMap) Action(msg tea.KeyMsg) Action {
+	return k.actions[msg.String()]
+}
+
+// Help returns the first key bound to action for display in hints, or "" if
+// the config left it unbound.
+func (k KeyMap) Help(action Action) string {
+	if keys := k.keys[action]; len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
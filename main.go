@@ -1,115 +1,863 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
+)
+
+// defaultPager is the diff renderer used when neither --pager nor the config sets one.
+const defaultPager = "delta"
+
+// defaultMaxRepos stops discovery from walking a huge tree, like a home
+// directory passed by mistake, for too long.
+const defaultMaxRepos = 500
+
+// Build information, set with -ldflags "-X main.version=...".
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
 )
 
+// defaultDiffTimeout bounds how long a diff may take to load before an error is shown.
+const defaultDiffTimeout = 10 * time.Second
+
+// defaultReconcile is how often the watcher rereads the line counts and hashes
+// it otherwise caches while a repo looks unchanged.
+const defaultReconcile = 10 * time.Second
+
+// defaultExecTimeout stops an --exec command that hangs, e.g. on a prompt, from
+// holding its repo's later runs back forever.
+const defaultExecTimeout = time.Minute
+
+// defaultMaxLineLength cuts diff lines far longer than any panel is wide, as in
+// minified or generated files, which are slow to render in full.
+const defaultMaxLineLength = 1000
+
+// Options holds runtime settings resolved from flags and the config file.
+type Options struct {
+	Pager     string              // diff rendering command; empty shows git's own colored output
+	ShowClean bool                // keep repos with no changes visible instead of pruning them
+	Keys      map[string][]string // key binding overrides, action name -> keys
+
+	DiffTimeout time.Duration // give up on loading a diff after this long; 0 waits indefinitely
+	AutoSelect  string        // when to select a file automatically, see AutoSelectOn
+	TabWidth    int           // columns per tab in delta's output; 0 keeps delta's default
+	MaxLineLen  int           // cut diff lines longer than this many characters; 0 keeps them whole
+	Coalesce    time.Duration // report each repo at most once per this window; 0 reports every poll
+	Reconcile   time.Duration // reread cached line counts and hashes this often; 0 never does
+
+	Theme        string            // color palette: ThemeAuto, ThemeDark, or ThemeLight
+	StatusColors map[string]string // status letter -> color overriding the palette's
+
+	FollowSymlinks bool // descend into symlinked directories during repo discovery
+	Submodules     bool // watch initialized submodules as separate repos
+	SkipRemote     bool // leave out repos on network filesystems
+	NoGit          bool // watch each path as a plain directory, compared to a snapshot taken at startup
+	MaxRepos       int  // stop discovery under each path after this many repos; 0 means no limit
+	MaxDepth       int  // how many directories below each path discovery looks; 0 means no limit
+
+	Exclude      []string // globs matched against repo names to leave out of discovery
+	NameTemplate string   // how repos are named in the tree, see DiscoverOptions.NameTemplate
+
+	NoRenames     bool // skip rename detection in git status, for speed on huge repos
+	UntrackedDirs bool // list untracked directories instead of every file in them
+	Ignored       bool // also list gitignored files
+	SameChanges   bool // flag files changed identically in several repos
+	AbsolutePaths bool // show files' absolute paths in the tree instead of repo-relative ones
+	StagedOnly    bool // list only files with staged changes, diffing just those
+	UnstagedOnly  bool // list only files with unstaged changes, diffing just those
+
+	MergeBase string // diff against where HEAD forked from this branch; "" diffs against the index and HEAD
+
+	DryRun bool // print what --save and --delete would change instead of writing the config
+	Stats  bool // print watcher statistics on exit
+	Stdin  bool // read the paths to watch from stdin, one per line
+	Notify bool // send a desktop notification when files start changing in a repo
+	Quiet  bool // print only errors before the TUI starts, no progress or warnings
+
+	Socket string // Unix socket to stream change events on as JSON lines; "" for none
+	Exec   string // shell command run in a repo whenever its changes update; "" for none
+	Resume bool   // watch what the last run watched and restore its session
+
+	ExecTimeout time.Duration // kill an --exec command after this long; 0 waits indefinitely
+
+	NoSession bool // don't save the session on quit, nor reopen its file
+
+	Git string // git executable to run, a name on PATH or a path to it
+}
+
 func main() {
-	// Check delta is available
-	if _, err := exec.LookPath("delta"); err != nil {
-		fmt.Fprintln(os.Stderr, "Error: 'delta' is not installed or not on PATH.")
-		fmt.Fprintln(os.Stderr, "Install it with: brew install git-delta")
-		os.Exit(1)
-	}
+	os.Exit(run())
+}
 
-	args := os.Args[1:]
+// run is main, returning the exit status so deferred cleanup, such as
+// removing --no-git snapshots, happens before the process exits.
+func run() int {
+	opts, args, err := parseOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
 
 	// Handle flags
 	if len(args) > 0 {
 		switch args[0] {
 		case "--help", "-h":
 			printUsage()
-			return
+			return 0
+		case "--version":
+			printVersion()
+			return 0
 		case "--list":
 			listProfiles()
-			return
+			return 0
+		case "--profile-names":
+			// Used by the completion scripts, so it prints nothing else
+			printProfileNames()
+			return 0
+		case "--completion":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Usage: diffwatch --completion <bash|zsh|fish>")
+				return 1
+			}
+			if err := printCompletion(args[1]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			return 0
 		case "--save":
 			if len(args) < 3 {
 				fmt.Fprintln(os.Stderr, "Usage: diffwatch --save <profile-name> <path>...")
-				os.Exit(1)
+				return 1
 			}
-			saveProfile(args[1], args[2:])
-			return
+			saveProfile(args[1], args[2:], opts.DryRun)
+			return 0
 		case "--delete":
 			if len(args) < 2 {
 				fmt.Fprintln(os.Stderr, "Usage: diffwatch --delete <profile-name>")
-				os.Exit(1)
+				return 1
 			}
-			deleteProfile(args[1])
-			return
+			deleteProfile(args[1], opts.DryRun)
+			return 0
 		}
 	}
 
-	// Resolve paths: check if single arg is a profile name
-	paths := args
-	if len(paths) == 1 {
-		if profilePaths := resolveProfile(paths[0]); profilePaths != nil {
-			paths = profilePaths
+	if err := checkGit(opts.Git); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	diffwatch.SetGitBinary(opts.Git)
+	checkPager(&opts)
+	checkNotifier(&opts)
+	keys, err := NewKeyMap(opts.Keys)
+	if err != nil && !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	theme, err := NewTheme(opts.Theme).WithStatusColors(opts.StatusColors)
+	if err != nil && !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	if len(args) == 1 && args[0] == "-" {
+		opts.Stdin = true
+		args = nil
+	}
+	source := newRepoSource(args)
+	if opts.Stdin {
+		stdinPaths, err := readPaths(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading paths from stdin: %v\n", err)
+			return 1
+		}
+		if len(stdinPaths) == 0 && len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "No paths given on stdin.")
+			return 1
 		}
+		// Paths and profiles given as arguments are watched too
+		if len(args) == 0 {
+			source = repoSource{}
+		}
+		source.paths = append(source.paths, stdinPaths...)
 	}
-	if len(paths) == 0 {
-		// Try "default" profile, fall back to "."
-		if profilePaths := resolveProfile("default"); profilePaths != nil {
-			paths = profilePaths
-		} else {
-			paths = []string{"."}
+	var session *Session
+	if opts.Resume {
+		if len(args) > 0 || opts.Stdin {
+			fmt.Fprintln(os.Stderr, "--resume watches what the last session watched; don't give paths or profiles with it.")
+			return 1
 		}
+		session, err = loadSession()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		source = repoSource{profiles: session.Profiles, paths: session.Paths}
+	}
+	paths, err := source.resolve()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
 	}
 
 	// Discover repos from all paths
-	var allRepos []Repo
-	for _, path := range paths {
-		repos, err := DiscoverRepos(path)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: could not scan %s: %v\n", path, err)
+	defer diffwatch.RemoveSnapshots()
+	allRepos, namePrefix, problems := discoverAll(paths, opts, !opts.Quiet)
+	for _, problem := range problems {
+		if opts.Quiet && strings.HasPrefix(problem, "Warning:") {
 			continue
 		}
-		allRepos = append(allRepos, repos...)
+		fmt.Fprintln(os.Stderr, problem)
 	}
 
 	if len(allRepos) == 0 {
-		fmt.Fprintln(os.Stderr, "No git repositories found in the specified paths.")
-		os.Exit(1)
+		if opts.NoGit {
+			fmt.Fprintln(os.Stderr, "No directories to watch found in the specified paths.")
+		} else {
+			fmt.Fprintln(os.Stderr, "No git repositories found in the specified paths.")
+		}
+		return 1
 	}
 
-	fmt.Printf("Found %d repo(s), starting diffwatch...\n", len(allRepos))
+	if !opts.Quiet {
+		fmt.Printf("Found %d repo(s), starting diffwatch...\n", len(allRepos))
+	}
 
 	// Start watcher
-	watcher, err := NewWatcher(allRepos)
+	watcher, err := diffwatch.NewWatcher(context.Background(), allRepos, opts.StatusOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error starting file watcher: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 	defer watcher.Close()
+	watcher.SetCoalesce(opts.Coalesce)
+	watcher.SetReconcile(opts.Reconcile)
 
 	// Start TUI
-	model := NewModel(allRepos, watcher)
-	p := tea.NewProgram(model, tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	model := NewModel(allRepos, watcher, source, opts, keys, theme)
+	if opts.Socket != "" {
+		events, err := listenEvents(opts.Socket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listening on %s: %v\n", opts.Socket, err)
+			return 1
+		}
+		defer events.Close()
+		model.events = events
+	}
+	model.namePrefix = namePrefix
+	if session != nil {
+		model.restoreSession(session)
+	} else if last, err := loadSession(); err == nil && !opts.NoSession {
+		model.reopenFile(last)
+	}
+	programOpts := []tea.ProgramOption{tea.WithAltScreen()}
+	if opts.Stdin {
+		// stdin was the path list, so read keys from the terminal instead
+		programOpts = append(programOpts, tea.WithInputTTY())
+	}
+	p := tea.NewProgram(model, programOpts...)
+	final, err := p.Run()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		return 1
+	}
+	// A profile switch replaces the watcher, so close the one the session ended with
+	if m, ok := final.(Model); ok {
+		m.stopHooks()
+		watcher = m.watcher
+		watcher.Close()
+		// A --no-git session's snapshots are gone by the next run, so it
+		// couldn't be resumed as it was
+		if !opts.NoSession && !opts.NoGit {
+			if err := saveSession(m.Session()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving session: %v\n", err)
+			}
+		}
+	}
+	if opts.Stats {
+		printStats(watcher.Stats())
+	}
+	return 0
+}
+
+// printVersion prints the version, commit, and build date. They are set with
+// -ldflags -X by `make install`; for `go install` builds they come from the
+// module version and VCS stamp in the binary's build info.
+func printVersion() {
+	v, c, d := version, commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			}
+		}
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	fmt.Printf("diffwatch %s (commit %s, built %s)\n", v, c, d)
+}
+
+// readPaths reads newline-separated paths from r, skipping blank lines and
+// expanding a leading ~.
+func readPaths(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			paths = append(paths, expandPath(line))
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// printStats reports what the watcher did during the session, to help tune
+// discovery limits on large trees.
+func printStats(s diffwatch.Stats) {
+	fmt.Printf("Repos watched:      %d\n", s.Repos)
+	fmt.Printf("Polls:              %d\n", s.Polls)
+	fmt.Printf("Failed git status:  %d\n", s.Failures)
+	fmt.Printf("Changes delivered:  %d\n", s.Changes)
+	fmt.Printf("Last poll:          %s\n", s.LastPoll.Round(time.Millisecond))
+	fmt.Printf("Slowest poll:       %s\n", s.MaxPoll.Round(time.Millisecond))
+}
+
+// parseOptions extracts option flags from args and fills unset options from the
+// config file. It returns the options and the remaining arguments.
+func parseOptions(args []string) (Options, []string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		cfg = &Config{}
+	}
+	opts := Options{
+		Pager:       defaultPager,
+		ShowClean:   cfg.ShowClean,
+		Keys:        cfg.Keys,
+		DiffTimeout: defaultDiffTimeout,
+		AutoSelect:  AutoSelectOn,
+		Theme:       ThemeAuto,
+		MaxRepos:    defaultMaxRepos,
+		MaxLineLen:  defaultMaxLineLength,
+		Reconcile:   defaultReconcile,
+		ExecTimeout: defaultExecTimeout,
+		Git:         "git",
+	}
+	if git := os.Getenv("DIFFWATCH_GIT"); git != "" {
+		opts.Git = git
+	}
+	if cfg.AutoSelect != "" {
+		opts.AutoSelect = cfg.AutoSelect
+	}
+	if cfg.Pager != "" {
+		opts.Pager = cfg.Pager
+	}
+	if cfg.Theme != "" {
+		opts.Theme = cfg.Theme
+	}
+	opts.StatusColors = cfg.StatusColors
+	if cfg.MaxRepos != nil {
+		opts.MaxRepos = *cfg.MaxRepos
+	}
+	if cfg.MaxLineLength != nil {
+		opts.MaxLineLen = *cfg.MaxLineLength
+	}
+	opts.MaxDepth = cfg.MaxDepth
+	if cfg.Session != nil {
+		opts.NoSession = !*cfg.Session
+	}
+	opts.NoRenames = cfg.NoRenames
+	opts.UntrackedDirs = cfg.UntrackedDirs
+	opts.Notify = cfg.Notify
+	opts.TabWidth = cfg.TabWidth
+	opts.NameTemplate = cfg.NameTemplate
+	opts.AbsolutePaths = cfg.AbsolutePaths
+	if cfg.DiffTimeout != "" {
+		d, err := time.ParseDuration(cfg.DiffTimeout)
+		if err != nil {
+			return opts, nil, fmt.Errorf("invalid diffTimeout in config: %v", err)
+		}
+		opts.DiffTimeout = d
+	}
+	if cfg.Coalesce != "" {
+		d, err := time.ParseDuration(cfg.Coalesce)
+		if err != nil {
+			return opts, nil, fmt.Errorf("invalid coalesce in config: %v", err)
+		}
+		opts.Coalesce = d
+	}
+	if cfg.Reconcile != "" {
+		d, err := time.ParseDuration(cfg.Reconcile)
+		if err != nil {
+			return opts, nil, fmt.Errorf("invalid reconcile in config: %v", err)
+		}
+		opts.Reconcile = d
+	}
+
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--pager":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--pager requires a command")
+			}
+			i++
+			opts.Pager = args[i]
+		case strings.HasPrefix(arg, "--pager="):
+			opts.Pager = strings.TrimPrefix(arg, "--pager=")
+		case arg == "--show-clean":
+			opts.ShowClean = true
+		case arg == "--follow-symlinks":
+			opts.FollowSymlinks = true
+		case arg == "--submodules":
+			opts.Submodules = true
+		case arg == "--staged-only":
+			opts.StagedOnly = true
+		case arg == "--unstaged-only":
+			opts.UnstagedOnly = true
+		case arg == "--same-changes":
+			opts.SameChanges = true
+		case arg == "--skip-remote":
+			opts.SkipRemote = true
+		case arg == "--no-git":
+			opts.NoGit = true
+		case arg == "--no-renames":
+			opts.NoRenames = true
+		case arg == "--untracked-dirs":
+			opts.UntrackedDirs = true
+		case arg == "--ignored":
+			opts.Ignored = true
+		case arg == "--absolute-paths":
+			opts.AbsolutePaths = true
+		case arg == "--quiet", arg == "-q":
+			opts.Quiet = true
+		case arg == "--notify":
+			opts.Notify = true
+		case arg == "--stdin":
+			opts.Stdin = true
+		case arg == "--resume":
+			opts.Resume = true
+		case arg == "--no-session":
+			opts.NoSession = true
+		case arg == "--stats":
+			opts.Stats = true
+		case arg == "--dry-run":
+			opts.DryRun = true
+		case arg == "--diff-timeout":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--diff-timeout requires a duration")
+			}
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil {
+				return opts, nil, fmt.Errorf("invalid --diff-timeout: %v", err)
+			}
+			opts.DiffTimeout = d
+		case strings.HasPrefix(arg, "--diff-timeout="):
+			d, err := time.ParseDuration(strings.TrimPrefix(arg, "--diff-timeout="))
+			if err != nil {
+				return opts, nil, fmt.Errorf("invalid --diff-timeout: %v", err)
+			}
+			opts.DiffTimeout = d
+		case arg == "--coalesce":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--coalesce requires a duration")
+			}
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil || d < 0 {
+				return opts, nil, fmt.Errorf("invalid --coalesce %q: use a duration such as 3s", args[i])
+			}
+			opts.Coalesce = d
+		case arg == "--reconcile":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--reconcile requires a duration")
+			}
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil || d < 0 {
+				return opts, nil, fmt.Errorf("invalid --reconcile %q: use a duration such as 10s", args[i])
+			}
+			opts.Reconcile = d
+		case arg == "--max-repos":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--max-repos requires a number")
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return opts, nil, fmt.Errorf("invalid --max-repos %q: use a number, or 0 for no limit", args[i])
+			}
+			opts.MaxRepos = n
+		case arg == "--max-line-length":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--max-line-length requires a number")
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return opts, nil, fmt.Errorf("invalid --max-line-length %q: use a number, or 0 for no limit", args[i])
+			}
+			opts.MaxLineLen = n
+		case arg == "--max-depth":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--max-depth requires a number")
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return opts, nil, fmt.Errorf("invalid --max-depth %q: use a number, or 0 for no limit", args[i])
+			}
+			opts.MaxDepth = n
+		case arg == "--exclude":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--exclude requires a glob")
+			}
+			i++
+			if _, err := filepath.Match(args[i], ""); err != nil {
+				return opts, nil, fmt.Errorf("invalid --exclude %q: %v", args[i], err)
+			}
+			opts.Exclude = append(opts.Exclude, args[i])
+		case arg == "--merge-base":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--merge-base requires a branch, e.g. main")
+			}
+			i++
+			opts.MergeBase = args[i]
+		case arg == "--git":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--git requires a path")
+			}
+			i++
+			opts.Git = args[i]
+		case strings.HasPrefix(arg, "--git="):
+			opts.Git = strings.TrimPrefix(arg, "--git=")
+		case arg == "--socket":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--socket requires a path")
+			}
+			i++
+			opts.Socket = args[i]
+		case arg == "--exec":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--exec requires a command, e.g. 'make lint'")
+			}
+			i++
+			opts.Exec = args[i]
+		case arg == "--exec-timeout":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--exec-timeout requires a duration")
+			}
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil || d < 0 {
+				return opts, nil, fmt.Errorf("invalid --exec-timeout %q: use a duration such as 30s", args[i])
+			}
+			opts.ExecTimeout = d
+		case arg == "--name-template":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--name-template requires a template, e.g. {parent}/{base}")
+			}
+			i++
+			opts.NameTemplate = args[i]
+		case arg == "--tab-width":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--tab-width requires a number")
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return opts, nil, fmt.Errorf("invalid --tab-width %q: use a number of columns", args[i])
+			}
+			opts.TabWidth = n
+		case arg == "--auto-select":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--auto-select requires on, off, or idle")
+			}
+			i++
+			opts.AutoSelect = args[i]
+		case arg == "--theme":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("--theme requires auto, dark, or light")
+			}
+			i++
+			opts.Theme = args[i]
+		case arg == "--light":
+			opts.Theme = ThemeLight
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if opts.StagedOnly && opts.UnstagedOnly {
+		return opts, nil, fmt.Errorf("--staged-only and --unstaged-only can't be combined")
+	}
+	if (opts.StagedOnly || opts.UnstagedOnly) && opts.MergeBase != "" {
+		return opts, nil, fmt.Errorf("--merge-base diffs everything since the fork point, so it can't be combined with --staged-only or --unstaged-only")
+	}
+	switch opts.AutoSelect {
+	case AutoSelectOn, AutoSelectOff, AutoSelectIdle:
+	default:
+		return opts, nil, fmt.Errorf("invalid auto-select mode %q: use on, off, or idle", opts.AutoSelect)
+	}
+	switch opts.Theme {
+	case ThemeAuto, ThemeDark, ThemeLight:
+	default:
+		return opts, nil, fmt.Errorf("invalid theme %q: use auto, dark, or light", opts.Theme)
+	}
+	return opts, rest, nil
+}
+
+// StatusOptions returns the git status settings for GetChangedFiles.
+func (o Options) StatusOptions() diffwatch.StatusOptions {
+	return diffwatch.StatusOptions{
+		NoRenames:     o.NoRenames,
+		UntrackedDirs: o.UntrackedDirs,
+		Ignored:       o.Ignored,
+		MergeBase:     o.MergeBase,
+		HashDiffs:     o.SameChanges,
+		StagedOnly:    o.StagedOnly,
+		UnstagedOnly:  o.UnstagedOnly,
+	}
+}
+
+// checkPath reports why path can't be watched: it doesn't exist, or it is a
+// directory that can't be read. Discovery would otherwise skip it silently.
+func checkPath(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: no such file or directory", path)
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("%s: cannot read directory", path)
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// checkGit fails when git can't be run. Unlike a missing pager there's nothing
+// to fall back to: even --no-git diffs run git diff --no-index.
+func checkGit(git string) error {
+	if _, err := exec.LookPath(git); err != nil {
+		return fmt.Errorf("'%s' is not installed or not executable; point --git or DIFFWATCH_GIT at git", git)
+	}
+	return nil
+}
+
+// checkPager warns when the configured pager is not on PATH and falls back to
+// git's own colored output so diffs still render.
+func checkPager(opts *Options) {
+	fields := strings.Fields(opts.Pager)
+	if len(fields) == 0 {
+		return
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Warning: '%s' is not installed or not on PATH, showing plain git diffs.\n", fields[0])
+			if fields[0] == "delta" {
+				fmt.Fprintln(os.Stderr, "Install it with: brew install git-delta")
+			}
+		}
+		opts.Pager = ""
+	}
+}
+
+// checkNotifier warns and turns off --notify when no notifier is installed.
+func checkNotifier(opts *Options) {
+	if opts.Notify && notifierCommand("", "") == nil {
+		if !opts.Quiet {
+			fmt.Fprintln(os.Stderr, "Warning: --notify needs terminal-notifier or notify-send on PATH, notifications are off.")
+		}
+		opts.Notify = false
 	}
 }
 
 func printUsage() {
-	fmt.Println(`diffwatch - watch git diffs across multiple repos
+	fmt.Println(usage)
+}
+
+// usage is the --help text. Shell completion takes its flag names from it.
+const usage = `diffwatch - watch git diffs across multiple repos
 
 Usage:
-  diffwatch [paths...]           Watch repos at the given paths
-  diffwatch <profile>            Load a saved profile
+  diffwatch [paths...]           Watch repos (or single files) at the given paths
+  diffwatch <profile>...         Load one or more saved profiles, which can be
+                                 mixed with paths. A profile name wins over a
+                                 directory of the same name; write ./name to
+                                 watch the directory.
   diffwatch                      Use "default" profile, or watch "."
+  diffwatch --stdin              Watch the paths listed on stdin, one per line
+                                 (also: diffwatch -), along with any paths or
+                                 profiles given as arguments
+  diffwatch --resume             Watch what the last session watched, with the
+                                 same file selected, repos folded, files pinned
+                                 and view settings. Sessions are saved on quit,
+                                 except with --no-session or --no-git.
 
 Profiles:
   diffwatch --save <name> <path>...   Save a named profile
   diffwatch --delete <name>           Delete a profile
   diffwatch --list                    List saved profiles
 
+  Add --dry-run to --save or --delete to print the change without
+  writing the config.
+
+Options:
+  --pager <cmd>    Diff renderer: delta (default), diff-so-fancy, difft,
+                   or any command reading a diff on stdin. Use "" for plain
+                   git output. Can also be set as "pager" in the config.
+  --show-clean     Keep repos without changes in the tree, marked (clean).
+                   Toggle at runtime with C. Config key: "showClean".
+  --follow-symlinks
+                   Descend into symlinked directories when looking for repos.
+  --submodules     Watch each initialized submodule as its own repo. Without
+                   it, a submodule of another watched repo is skipped with a
+                   warning, since its parent's status already lists it.
+  --no-git         Watch each path as a plain directory, not a git repo, and
+                   list the files created, modified, or deleted since
+                   diffwatch started, diffed against a copy taken then.
+                   Files over 1 MiB, and any past the first 256 MiB
+                   copied, are listed but can't be diffed.
+  --skip-remote    Leave out repos on network filesystems such as NFS, SMB
+                   or sshfs, where git status is slow. Without it they are
+                   watched, with a warning naming each one.
+  --max-repos <n>  Stop looking for repos under a path after finding n
+                   (default 500, 0 for no limit). Config key: "maxRepos".
+  --max-depth <n>  Look at most n directories below each path for repos
+                   (default 0, no limit). Config key: "maxDepth".
+  --exclude <glob> Leave out repos whose name matches glob, e.g. "shopify/*"
+                   or "scratch". Can be given more than once.
+  --name-template <template>
+                   How repos are named in the tree, using {name} (the default
+                   name), {rel} (path below the watched path), {base} (the
+                   repo's directory) and {parent} (the directory above it),
+                   e.g. "{parent}/{base}". Config key: "nameTemplate".
+  --no-renames     Skip rename detection in git status, which is slow on
+                   huge repos. Renamed files show as a delete plus an add.
+                   Config key: "noRenames".
+  --untracked-dirs List a new directory as one entry instead of every file
+                   in it, so git status needn't walk untracked trees. Its
+                   diff can't be shown. Config key: "untrackedDirs".
+  --absolute-paths Show each file's absolute path in the tree instead of its
+                   path in the repo. Toggle at runtime with A. Config key:
+                   "absolutePaths".
+  --ignored        Also list files ignored by .gitignore, marked "!", e.g. to
+                   inspect build output. Toggle at runtime with I.
+  --staged-only    List only files with staged changes, and show only those
+                   changes in their diffs, e.g. to review what's about to be
+                   committed. Conflicted files are always listed.
+  --unstaged-only  List only files with changes not yet staged (untracked
+                   files included), and show only those changes.
+  --same-changes   Mark files whose change is identical in several repos, e.g.
+                   "(same in 4 repos)", for the same edit made across many
+                   clones. Runs an extra git diff per repo on each change.
+  --merge-base <branch>
+                   Diff each repo against the commit where HEAD forked from
+                   branch, e.g. main, so committed work on the current branch
+                   shows too, like in a pull request. A repo without that
+                   branch shows an error instead of its files.
+  --notify         Send a desktop notification (via terminal-notifier or
+                   notify-send) when new files change in a repo, at most
+                   once every 30s per repo. Config key: "notify".
+  --socket <path>  Stream change events on a Unix socket, one JSON object per
+                   line with a repo's changed files each time they change,
+                   for editor integrations. A client that connects first gets
+                   every repo's current state.
+  --exec <command> Run command with sh in a repo's root whenever its changed
+                   files update. DIFFWATCH_REPO, DIFFWATCH_REPO_NAME and
+                   DIFFWATCH_FILES (one path per line) describe the change.
+                   A repo runs one command at a time; changes made meanwhile
+                   run it once more when it finishes. Failures show in the
+                   status bar. A command still running on quit is killed.
+  --exec-timeout <duration>
+                   Kill an --exec command that runs longer than this
+                   (default 1m, 0 for no limit).
+  --git <path>     The git executable to run, e.g. a newer build outside
+                   PATH (default "git" from PATH). DIFFWATCH_GIT sets it too;
+                   the flag wins.
+  --no-session     Don't save the session on quit, for --resume, nor reopen
+                   the last session's file on start. Config key: "session"
+                   set to false.
+  -q, --quiet      Print nothing before the TUI starts except errors: no
+                   discovery progress, repo count, or warnings.
+  --stats          Print polling statistics on exit: repos watched, poll
+                   count, failed git status runs, and poll durations.
+  --diff-timeout <duration>
+                   Give up loading a diff after this long (default 10s, 0 for
+                   no limit). Config key: "diffTimeout".
+  --coalesce <duration>
+                   Update a repo at most once per duration, e.g. 3s, showing
+                   the state it has reached by then, so a rebase or other
+                   burst of git operations doesn't refresh the tree every
+                   second (default 0, every poll). The status bar shows
+                   "changes detected" while a change is held back. Config
+                   key: "coalesce".
+  --reconcile <duration>
+                   How often to recount every repo's changed lines from
+                   scratch, as a safety net. Between times, counts are only
+                   redone when git status or a file's size or mtime changes,
+                   which can miss a quick same-size edit (default 10s, 0 to
+                   never). Config key: "reconcile".
+  --tab-width <n>  Columns per tab in diffs rendered by delta (delta --tabs).
+                   Defaults to delta's own setting. Config key: "tabWidth".
+  --max-line-length <n>
+                   Cut diff lines longer than n characters, e.g. a minified
+                   file's one huge line, ending them with "…" (default 1000, 0
+                   for no limit). Config key: "maxLineLength".
+  --auto-select <on|off|idle>
+                   Whether to select a file automatically when none is
+                   selected: always (default), never, or only after 3s
+                   without key presses. Config key: "autoSelect".
+  --theme <auto|dark|light>
+                   Color palette. auto (default) picks one from the terminal
+                   background. Config key: "theme".
+  --light          Same as --theme light.
+  --version        Print the version, commit, and build date.
+  --completion <bash|zsh|fish>
+                   Print a shell completion script, which completes flags,
+                   paths, and saved profile names. Load it with e.g.
+                   source <(diffwatch --completion bash).
+
+Key bindings can be changed with a "keys" object in the config, mapping
+action names (e.g. "navigate-down", "next-hunk", "quit") to lists of keys.
+
+The colors of status letters can be changed with a "statusColors" object in
+the config, mapping letters (M, A, D, R, C, ?, !, U) to an ANSI color index or
+a hex color, e.g. {"M": "214", "?": "#808080"}.
+
 Examples:
   diffwatch . ~/src/other-repo
+  diffwatch config/app.yml db/schema.rb
   diffwatch --save work . ~/src/other-repo
-  diffwatch work`)
-}
+  diffwatch work
+  diffwatch work personal ~/src/scratch
+  diffwatch --pager diff-so-fancy .
+  fd -t d -H '^.git$' ~/src -x dirname | diffwatch -`
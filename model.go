@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
 )
 
 // Panel identifies which panel has focus.
@@ -18,54 +25,165 @@ const (
 	RightPanel
 )
 
+// StatusErrMsg reports an error to show in the status bar.
+type StatusErrMsg struct {
+	Err error
+}
+
+// StatusInfoMsg reports the outcome of an action to show in the status bar.
+type StatusInfoMsg struct {
+	Text string
+}
+
+// Bounds for the number of context lines shown around each change.
+const (
+	defaultContext = 3
+	maxContext     = 20
+)
+
+// scanResultMsg carries the result of an explicit scan (startup or refresh),
+// as opposed to a change reported by the watcher.
+type scanResultMsg struct {
+	FilesChangedMsg
+}
+
+// notifyInterval is the least time between two desktop notifications for a repo,
+// so a save touching many files, or a run of saves, sends one.
+const notifyInterval = 30 * time.Second
+
+// diffKey identifies a diff: the same file with the same status, rendered with
+// the same options.
+type diffKey struct {
+	file diffwatch.ChangedFile
+	opts diffwatch.DiffOptions
+}
+
+// prefetchedDiff is a diff loaded ahead of its file being selected.
+type prefetchedDiff struct {
+	content string
+	at      time.Time
+}
+
+// prefetchTTL is how long a prefetched diff may be shown before it's considered
+// stale. The watcher reports an edit to a file's content only when it changes
+// the file's line counts (or its DiffHash, with --same-changes), so an edit
+// that keeps them, like retyping a changed line, goes unnoticed.
+const prefetchTTL = 10 * time.Second
+
+// diffPrefetchedMsg carries a diff loaded in the background by prefetchDiff.
+type diffPrefetchedMsg struct {
+	key     diffKey
+	content string
+}
+
 // Model is the root bubbletea model that owns layout and dispatches to sub-models.
 type Model struct {
-	filetree FileTreeModel
-	diffview DiffViewModel
-	focus    Panel
-	width    int
-	height   int
-	splitPos float64 // 0.0 to 1.0, default 0.3
-	repos    []Repo
-	watcher  *Watcher
-}
-
-// NewModel creates a new root model with the given repos and watcher.
-func NewModel(repos []Repo, watcher *Watcher) Model {
-	return Model{
-		filetree: NewFileTreeModel(),
-		diffview: NewDiffViewModel(),
+	filetree   FileTreeModel
+	diffview   DiffViewModel
+	focus      Panel
+	width      int
+	height     int
+	splitPos   float64 // 0.0 to 1.0, default 0.3
+	repos      []diffwatch.Repo
+	watcher    *diffwatch.Watcher
+	source     repoSource // where repos came from, for reloading
+	opts       Options    // startup options, for rediscovering repos
+	diffOpts   diffwatch.DiffOptions
+	statusOpts diffwatch.StatusOptions
+	statusErr  error  // shown in the status bar until the next key press
+	statusInfo string // likewise, for non-error outcomes
+	spinner    spinner.Model
+	scanning   int // explicit scans still in flight
+	keys       KeyMap
+	theme      Theme
+	paused     bool // ignore watcher updates until resumed
+	zoomed     bool // the diff fills the screen and the file tree is hidden
+
+	prefetched map[diffKey]prefetchedDiff // diffs of files next to the selection, each used at most once
+	picker     *profilePicker             // open profile list, shown in place of the file tree; nil when closed
+	notified   map[string]time.Time       // repo WatchPath -> last desktop notification, for throttling
+	events     *eventServer               // --socket event stream, or nil
+	namePrefix string                     // directories stripped from every repo name, shown in the title
+
+	resume *Session // session whose selected file is restored once its repo is scanned, see reopenFile; nil otherwise
+
+	// execs holds the repos whose --exec command is running, by WatchPath,
+	// with the change to run it for again once it ends, or nil if none came in.
+	execs map[string]*FilesChangedMsg
+
+	hooks     context.Context    // --exec commands run under it, so quitting kills them
+	stopHooks context.CancelFunc // cancels hooks
+
+	pending map[string]bool // repo WatchPaths with a change --coalesce is holding back
+}
+
+// NewModel creates a new root model with the given repos, watcher, options, and key bindings.
+func NewModel(repos []diffwatch.Repo, watcher *diffwatch.Watcher, source repoSource, opts Options, keys KeyMap, theme Theme) Model {
+	hooks, stopHooks := context.WithCancel(context.Background())
+	m := Model{
+		filetree: NewFileTreeModel(opts.ShowClean, opts.AutoSelect, keys, theme),
+		diffview: NewDiffViewModel(keys, theme),
+		keys:     keys,
+		theme:    theme,
 		focus:    LeftPanel,
 		splitPos: 0.3,
 		repos:    repos,
 		watcher:  watcher,
+		source:   source,
+		opts:     opts,
+		diffOpts: diffwatch.DiffOptions{
+			Pager:    opts.Pager,
+			Context:  defaultContext,
+			Timeout:  opts.DiffTimeout,
+			TabWidth: opts.TabWidth,
+
+			MaxLineLength: opts.MaxLineLen,
+		},
+		statusOpts: opts.StatusOptions(),
+		prefetched: make(map[diffKey]prefetchedDiff),
+		notified:   make(map[string]time.Time),
+		execs:      make(map[string]*FilesChangedMsg),
+		hooks:      hooks,
+		stopHooks:  stopHooks,
+		pending:    make(map[string]bool),
+		spinner:    spinner.New(spinner.WithSpinner(spinner.MiniDot)),
+		scanning:   len(repos),
 	}
+	m.filetree.absolute = opts.AbsolutePaths
+	return m
 }
 
 // Init implements tea.Model. Does initial file scan and starts listening for changes.
 func (m Model) Init() tea.Cmd {
-	return tea.Batch(m.initialScan(), m.watcher.WaitForChange())
+	return tea.Batch(m.initialScan(), waitForChange(m.watcher), m.spinner.Tick)
 }
 
 // initialScan runs GetChangedFiles for all repos concurrently.
 func (m *Model) initialScan() tea.Cmd {
 	var cmds []tea.Cmd
 	for i := range m.repos {
-		repo := &m.repos[i]
-		cmds = append(cmds, func() tea.Msg {
-			files, err := GetChangedFiles(repo)
-			if err != nil || len(files) == 0 {
-				return nil
-			}
-			return FilesChangedMsg{
-				Repo:  repo,
-				Files: files,
-			}
-		})
+		cmds = append(cmds, scanRepo(&m.repos[i], m.statusOpts))
 	}
 	return tea.Batch(cmds...)
 }
 
+// scanRepo returns a tea.Cmd that runs GetRepoStatus for a single repo.
+func scanRepo(repo *diffwatch.Repo, opts diffwatch.StatusOptions) tea.Cmd {
+	return func() tea.Msg {
+		status, err := diffwatch.GetRepoStatus(repo, opts)
+		if err != nil {
+			return scanResultMsg{FilesChangedMsg{Repo: repo, Err: err}}
+		}
+		return scanResultMsg{FilesChangedMsg{
+			Repo:   repo,
+			Files:  status.Files,
+			State:  diffwatch.RepoState(repo),
+			Ahead:  status.Ahead,
+			Behind: status.Behind,
+		}}
+	}
+}
+
 // Update implements tea.Model.
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
@@ -76,23 +194,129 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "q":
+		m.statusErr = nil
+		m.statusInfo = ""
+		if m.picker != nil {
+			return m.updatePicker(msg)
+		}
+		switch m.keys.Action(msg) {
+		case ActionQuit:
 			if m.filetree.filtering {
 				// Let filetree handle 'q' during filter mode
 				break
 			}
+			m.stopHooks()
 			return m, tea.Quit
-		case "tab":
+		case ActionSwitchPanel:
+			if m.zoomed {
+				// The tree is hidden while zoomed, so switching to it un-zooms
+				m.zoomed = false
+				m.updateSizes()
+			}
 			if m.focus == LeftPanel {
 				m.focus = RightPanel
 			} else {
 				m.focus = LeftPanel
 			}
 			return m, nil
-		case "r":
+		case ActionRefresh:
+			if !m.filetree.filtering {
+				return m, m.startRefresh()
+			}
+		case ActionExportPatch, ActionExportStaged:
+			if repo := m.filetree.CurrentRepo(); repo != nil && !m.filetree.filtering {
+				return m, exportPatch(repo, m.keys.Action(msg) == ActionExportStaged)
+			}
+		case ActionPause:
+			if !m.filetree.filtering {
+				m.paused = !m.paused
+				if !m.paused {
+					// Catch up on everything the watcher reported while paused
+					return m, m.startRefresh()
+				}
+				return m, nil
+			}
+		case ActionReveal:
+			if !m.filetree.filtering && m.filetree.selected != nil {
+				return m, revealFile(*m.filetree.selected)
+			}
+		case ActionStageHunk:
+			if m.focus == RightPanel && m.filetree.selected != nil {
+				if len(m.diffview.lines) == 0 {
+					m.statusErr = fmt.Errorf("no hunk to stage")
+					return m, nil
+				}
+				// Hunks are matched by line, as delta hides their @@ headers
+				return m, stageHunk(*m.filetree.selected, m.diffview.CursorSourceLine(), m.diffOpts)
+			}
+		case ActionOpenEditor:
+			if !m.filetree.filtering && m.filetree.selected != nil {
+				// From the diff, open at the line under the cursor
+				line := 1
+				if m.focus == RightPanel {
+					line = m.diffview.CursorSourceLine()
+				}
+				return m, openInEditor(*m.filetree.selected, line)
+			}
+		case ActionOpenPager:
+			if !m.filetree.filtering && m.filetree.selected != nil {
+				return m, openInPager(*m.filetree.selected, m.diffOpts)
+			}
+		case ActionMoreContext:
+			if !m.filetree.filtering {
+				return m, m.setContext(m.diffOpts.Context + 1)
+			}
+		case ActionLessContext:
+			if !m.filetree.filtering {
+				return m, m.setContext(m.diffOpts.Context - 1)
+			}
+		case ActionToggleWhitespace:
+			if !m.filetree.filtering {
+				m.diffOpts.IgnoreWhitespace = !m.diffOpts.IgnoreWhitespace
+				return m, m.reloadDiff()
+			}
+		case ActionSwitchProfile:
+			if !m.filetree.filtering {
+				picker, err := newProfilePicker(m.source.profile())
+				if err != nil {
+					m.statusErr = err
+					return m, nil
+				}
+				// The list replaces the file tree, so make sure it's on screen
+				m.picker = picker
+				m.focus = LeftPanel
+				m.zoomed = false
+				m.updateSizes()
+				return m, nil
+			}
+		case ActionReloadConfig:
+			if !m.filetree.filtering {
+				return m, reloadRepos(m.source, m.opts)
+			}
+		case ActionZoom:
+			if !m.filetree.filtering {
+				m.zoomed = !m.zoomed
+				if m.zoomed {
+					m.focus = RightPanel
+				}
+				m.updateSizes()
+				return m, nil
+			}
+		case ActionToggleIgnored:
+			if !m.filetree.filtering {
+				m.statusOpts.Ignored = !m.statusOpts.Ignored
+				m.watcher.SetStatusOptions(m.statusOpts)
+				return m, m.startRefresh()
+			}
+		case ActionToggleClean:
 			if !m.filetree.filtering {
-				return m, m.refreshAll()
+				m.filetree.ToggleShowClean()
+				return m, nil
+			}
+		case ActionToggleLegend:
+			if !m.filetree.filtering {
+				m.filetree.legend = !m.filetree.legend
+				return m, nil
 			}
 		}
 
@@ -107,91 +331,397 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, cmd
 
 	case FilesChangedMsg:
+		if msg.watcher != m.watcher {
+			return m, nil // left over from a watcher replaced by a profile switch
+		}
+		if msg.Pending {
+			if !m.paused && m.watching(msg.Repo) {
+				m.pending[msg.Repo.WatchPath] = true
+			}
+			return m, waitForChange(m.watcher)
+		}
+		delete(m.pending, msg.Repo.WatchPath)
+		if m.events != nil && m.watching(msg.Repo) {
+			// Clients get every change, even while the UI is paused
+			m.events.Publish(msg)
+		}
+		execCmd := m.execHook(msg)
+		if m.paused || !m.watching(msg.Repo) {
+			// Keep draining the watcher so it doesn't back up
+			return m, tea.Batch(execCmd, waitForChange(m.watcher))
+		}
+		notifyCmd := m.notifyNewFiles(msg)
 		var cmd tea.Cmd
 		m.filetree, cmd = m.filetree.Update(msg)
-		return m, tea.Batch(cmd, m.watcher.WaitForChange())
+		return m, tea.Batch(cmd, notifyCmd, execCmd, waitForChange(m.watcher))
+
+	case scanResultMsg:
+		m.scanning--
+		if !m.watching(msg.Repo) {
+			return m, nil // scanned before a reload removed it
+		}
+		var cmd tea.Cmd
+		m.filetree, cmd = m.filetree.Update(msg.FilesChangedMsg)
+		if m.resume != nil && msg.Repo.WatchPath == m.resume.SelectedRepo {
+			if sel := m.filetree.SelectFile(m.resume.SelectedRepo, m.resume.SelectedFile); sel != nil {
+				cmd = sel
+			}
+			m.resume = nil
+		}
+		return m, cmd
+
+	case spinner.TickMsg:
+		// Ticks are dropped while idle, which stops the animation until it's restarted
+		if msg.ID != m.spinner.ID() {
+			var cmd tea.Cmd
+			m.diffview, cmd = m.diffview.Update(msg)
+			return m, cmd
+		}
+		if m.scanning == 0 {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
 
 	case FileSelectedMsg:
-		m.diffview.SetLoading()
-		return m, loadDiff(msg.File)
+		key := diffKey{msg.File, m.diffOpts}
+		if p, ok := m.prefetched[key]; ok {
+			delete(m.prefetched, key)
+			if time.Since(p.at) < prefetchTTL {
+				m.diffview, _ = m.diffview.Update(DiffLoadedMsg{File: msg.File, Content: p.content, Opts: m.diffOpts})
+				return m, m.prefetchAdjacent()
+			}
+		}
+		return m, tea.Batch(m.diffview.SetLoading(), loadDiff(msg.File, m.diffOpts), m.prefetchAdjacent())
 
 	case DiffLoadedMsg:
+		// A slow load for a file the user has already moved past must not replace the current diff
+		if sel := m.filetree.selected; sel != nil &&
+			(sel.Repo.WatchPath != msg.File.Repo.WatchPath || sel.Path != msg.File.Path) {
+			return m, nil
+		}
 		m.diffview, _ = m.diffview.Update(msg)
 		return m, nil
+
+	case diffPrefetchedMsg:
+		m.prefetched[msg.key] = prefetchedDiff{content: msg.content, at: time.Now()}
+		return m, nil
+
+	case reposReloadedMsg:
+		if msg.err != nil {
+			m.statusErr = msg.err
+			return m, nil
+		}
+		if len(msg.repos) == 0 {
+			m.statusErr = fmt.Errorf("no git repositories found, keeping the current ones")
+			return m, nil
+		}
+		m.repos = msg.repos
+		m.namePrefix = msg.prefix
+		m.watcher.SetRepos(m.repos)
+		m.filetree.RetainRepos(m.repos)
+		if m.events != nil {
+			m.events.RetainRepos(m.repos)
+		}
+		clear(m.pending)
+		m.statusInfo = fmt.Sprintf("Reloaded config: %d repo(s)", len(m.repos))
+		if len(msg.problems) > 0 {
+			m.statusInfo += " | " + strings.Join(msg.problems, "; ")
+		}
+		return m, m.startRefresh()
+
+	case profileSwitchedMsg:
+		if msg.err != nil {
+			m.statusErr = msg.err
+			return m, nil
+		}
+		if len(msg.repos) == 0 {
+			m.statusErr = fmt.Errorf("profile '%s' has no git repositories, keeping the current one", msg.source.profile())
+			return m, nil
+		}
+		// Start over with a fresh watcher. Close waits for the old one's poll
+		// goroutine to exit, so it's closed off the UI goroutine.
+		watcher, err := diffwatch.NewWatcher(context.Background(), msg.repos, m.statusOpts)
+		if err != nil {
+			m.statusErr = err
+			return m, nil
+		}
+		watcher.SetCoalesce(m.opts.Coalesce)
+		watcher.SetReconcile(m.opts.Reconcile)
+		go m.watcher.Close()
+		m.watcher = watcher
+		m.source = msg.source
+		m.repos = msg.repos
+		m.namePrefix = msg.prefix
+		m.filetree.RetainRepos(m.repos)
+		if m.events != nil {
+			m.events.RetainRepos(m.repos)
+		}
+		m.diffview.Clear()
+		clear(m.prefetched)
+		clear(m.pending)
+		m.statusInfo = fmt.Sprintf("Switched to profile '%s': %d repo(s)", m.source.profile(), len(m.repos))
+		if len(msg.problems) > 0 {
+			m.statusInfo += " | " + strings.Join(msg.problems, "; ")
+		}
+		return m, tea.Batch(m.startRefresh(), waitForChange(m.watcher))
+
+	case hunkStagedMsg:
+		if msg.err != nil {
+			m.statusErr = fmt.Errorf("could not stage hunk: %w", msg.err)
+			return m, nil
+		}
+		m.statusInfo = fmt.Sprintf("Staged hunk %d of %s", msg.hunk+1, msg.file.Path)
+		return m, tea.Batch(m.reloadDiff(), m.startRefresh())
+
+	case StatusErrMsg:
+		m.statusErr = msg.Err
+		return m, nil
+
+	case StatusInfoMsg:
+		m.statusInfo = msg.Text
+		return m, nil
+
+	case freshExpiredMsg, autoSelectIdleMsg:
+		var cmd tea.Cmd
+		m.filetree, cmd = m.filetree.Update(msg)
+		return m, cmd
+
+	case execDoneMsg:
+		if msg.err != nil {
+			m.statusErr = msg.err
+		}
+		key := msg.repo.WatchPath
+		next := m.execs[key]
+		delete(m.execs, key)
+		if next != nil && m.watching(next.Repo) {
+			// Changes arrived while it ran; run once more for the latest
+			m.execs[key] = nil
+			return m, runExecHook(m.hooks, m.opts.Exec, m.opts.ExecTimeout, *next)
+		}
+		return m, nil
 	}
 
 	return m, nil
 }
 
+// updatePicker handles keys while the profile list is open.
+func (m Model) updatePicker(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.String() == "esc" {
+		m.picker = nil
+		return m, nil
+	}
+	switch m.keys.Action(msg) {
+	case ActionDown:
+		m.picker.cursor = min(m.picker.cursor+1, len(m.picker.names)-1)
+	case ActionUp:
+		m.picker.cursor = max(m.picker.cursor-1, 0)
+	case ActionSelect:
+		name := m.picker.names[m.picker.cursor]
+		m.picker = nil
+		return m, switchProfile(name, m.opts)
+	case ActionQuit, ActionSwitchProfile:
+		m.picker = nil
+	}
+	return m, nil
+}
+
+// watching reports whether repo is still one of the model's repos; results for
+// repos dropped by a config reload can arrive afterwards.
+func (m *Model) watching(repo *diffwatch.Repo) bool {
+	for i := range m.repos {
+		if m.repos[i].WatchPath == repo.WatchPath {
+			return true
+		}
+	}
+	return false
+}
+
+// execHook returns the command running --exec for msg's change, or nil if
+// --exec isn't set, the change is a repo's first report or an error, or the
+// repo's command is still running. In that case msg is kept to run when it
+// finishes, replacing any change kept before it.
+func (m *Model) execHook(msg FilesChangedMsg) tea.Cmd {
+	if m.opts.Exec == "" || msg.Err != nil || !m.filetree.Changes(msg) {
+		return nil
+	}
+	key := msg.Repo.WatchPath
+	if _, running := m.execs[key]; running {
+		m.execs[key] = &msg
+		return nil
+	}
+	m.execs[key] = nil
+	return runExecHook(m.hooks, m.opts.Exec, m.opts.ExecTimeout, msg)
+}
+
+// notifyNewFiles returns a command sending a desktop notification if msg lists
+// files that weren't changed before, unless notifications are off or the repo
+// had one less than notifyInterval ago.
+func (m *Model) notifyNewFiles(msg FilesChangedMsg) tea.Cmd {
+	if !m.opts.Notify {
+		return nil
+	}
+	added := m.filetree.NewFiles(msg)
+	if added == 0 {
+		return nil
+	}
+	key := msg.Repo.WatchPath
+	if time.Since(m.notified[key]) < notifyInterval {
+		return nil
+	}
+	m.notified[key] = time.Now()
+	return notify("diffwatch: "+msg.Repo.Name,
+		fmt.Sprintf("%d new changed file(s), %d in total", added, len(msg.Files)))
+}
+
+// setContext changes the number of diff context lines, clamped to [0, maxContext],
+// and reloads the selected file's diff if it changed.
+func (m *Model) setContext(n int) tea.Cmd {
+	n = max(0, min(n, maxContext))
+	if n == m.diffOpts.Context {
+		return nil
+	}
+	m.diffOpts.Context = n
+	return m.reloadDiff()
+}
+
+// reloadDiff reloads the diff for the selected file, e.g. after diff options change.
+func (m *Model) reloadDiff() tea.Cmd {
+	if m.filetree.selected == nil {
+		return nil
+	}
+	return tea.Batch(m.diffview.SetLoading(), loadDiff(*m.filetree.selected, m.diffOpts))
+}
+
+// prefetchAdjacent returns a command that loads the diffs of the files next to
+// the cursor in the background, so moving to them shows their diff at once.
+// Expired entries are dropped first so the cache stays small.
+func (m *Model) prefetchAdjacent() tea.Cmd {
+	for key, p := range m.prefetched {
+		if time.Since(p.at) >= prefetchTTL {
+			delete(m.prefetched, key)
+		}
+	}
+	var cmds []tea.Cmd
+	for _, file := range m.filetree.AdjacentFiles() {
+		key := diffKey{file, m.diffOpts}
+		if _, ok := m.prefetched[key]; !ok {
+			cmds = append(cmds, prefetchDiff(key))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// prefetchDiff returns a command that loads the diff for key without showing
+// it. Failed loads are dropped; selecting the file loads it again.
+func prefetchDiff(key diffKey) tea.Cmd {
+	return func() tea.Msg {
+		content, err := diffwatch.GetDiff(key.file, key.opts)
+		if err != nil {
+			return nil
+		}
+		return diffPrefetchedMsg{key: key, content: content}
+	}
+}
+
+// startRefresh re-scans all repos and starts the scanning indicator.
+func (m *Model) startRefresh() tea.Cmd {
+	cmds := []tea.Cmd{m.refreshAll()}
+	if m.scanning == 0 {
+		cmds = append(cmds, m.spinner.Tick)
+	}
+	m.scanning += len(m.repos)
+	return tea.Batch(cmds...)
+}
+
 // refreshAll re-scans all repos concurrently.
 func (m *Model) refreshAll() tea.Cmd {
 	var cmds []tea.Cmd
 	for i := range m.repos {
-		repo := &m.repos[i]
-		cmds = append(cmds, func() tea.Msg {
-			files, err := GetChangedFiles(repo)
-			if err != nil || len(files) == 0 {
-				return nil
-			}
-			return FilesChangedMsg{
-				Repo:  repo,
-				Files: files,
-			}
-		})
+		cmds = append(cmds, scanRepo(&m.repos[i], m.statusOpts))
 	}
 	return tea.Batch(cmds...)
 }
 
 // updateSizes recalculates sub-model dimensions.
 func (m *Model) updateSizes() {
-	leftWidth := int(float64(m.width) * m.splitPos)
-	rightWidth := m.width - leftWidth - 3 // 3 for borders/divider
-	contentHeight := m.height - 4         // borders + header
-
-	if leftWidth < 10 {
-		leftWidth = 10
-	}
-	if rightWidth < 10 {
-		rightWidth = 10
-	}
-	if contentHeight < 1 {
-		contentHeight = 1
-	}
+	leftWidth, rightWidth := m.panelWidths()
+	contentHeight := max(m.height-4, 1) // borders + header
 
 	m.filetree.SetSize(leftWidth, contentHeight)
 	m.diffview.SetSize(rightWidth, contentHeight)
 }
 
+// compactWidth is the terminal width below which only the focused panel is
+// shown, full width, and tab switches which one that is.
+const compactWidth = 80
+
+// singlePane reports whether only the focused panel is shown: the diff is
+// zoomed or the terminal is too narrow for both panels.
+func (m Model) singlePane() bool {
+	return m.zoomed || m.width < compactWidth
+}
+
+// panelWidths returns the content widths of the file tree and diff panels.
+// In single-pane mode each gets the whole width, since only one is shown.
+func (m Model) panelWidths() (left, right int) {
+	if m.singlePane() {
+		w := max(m.width-2, 10) // 2 for borders
+		return w, w
+	}
+	left = max(int(float64(m.width)*m.splitPos), 10)
+	right = max(m.width-left-3, 10) // 3 for borders/divider
+	return left, right
+}
+
 // View implements tea.Model.
 func (m Model) View() string {
 	if m.width == 0 || m.height == 0 {
 		return "Initializing..."
 	}
 
-	leftWidth := int(float64(m.width) * m.splitPos)
-	rightWidth := m.width - leftWidth - 3
-	contentHeight := m.height - 4
-
-	if leftWidth < 10 {
-		leftWidth = 10
-	}
-	if rightWidth < 10 {
-		rightWidth = 10
-	}
-	if contentHeight < 1 {
-		contentHeight = 1
-	}
+	leftWidth, rightWidth := m.panelWidths()
+	contentHeight := max(m.height-4, 1)
 
 	// Border styles
 	focusedBorder := lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("12"))
+		BorderForeground(m.theme.Accent)
 	unfocusedBorder := lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("8"))
+		BorderForeground(m.theme.Muted)
 
 	// Left panel
-	leftTitle := fmt.Sprintf(" Changed Files (%d) ", m.filetree.totalFileCount())
+	leftTitle := fmt.Sprintf("Changed Files (%d)", m.filetree.totalFileCount())
+	if m.namePrefix != "" {
+		leftTitle += " in " + m.namePrefix
+	}
+	if m.opts.MergeBase != "" {
+		leftTitle += " vs " + m.opts.MergeBase
+	}
+	if m.opts.StagedOnly {
+		leftTitle += " [staged]"
+	} else if m.opts.UnstagedOnly {
+		leftTitle += " [unstaged]"
+	}
+	if m.filetree.flat {
+		leftTitle += " [flat]"
+	}
+	if m.filetree.noUntracked {
+		leftTitle += " [untracked hidden]"
+	}
+	if m.filetree.absolute {
+		leftTitle += " [absolute]"
+	}
+	if crumb := m.filetree.SelectedBreadcrumb(); crumb != "" {
+		leftTitle += " › " + crumb
+	}
+	leftContent := m.filetree.View()
+	if m.picker != nil {
+		leftTitle = "Switch Profile (enter to pick, esc to cancel)"
+		leftContent = m.picker.View(contentHeight)
+	}
 	leftStyle := unfocusedBorder
 	if m.focus == LeftPanel {
 		leftStyle = focusedBorder
@@ -199,12 +729,19 @@ func (m Model) View() string {
 	leftPanel := leftStyle.
 		Width(leftWidth).
 		Height(contentHeight).
-		Render(m.filetree.View())
+		Render(leftContent)
 
 	// Right panel
-	rightTitle := " Diff "
+	rightTitle := "Diff"
+	rightContent := m.diffview.View()
 	if m.diffview.filePath != "" {
-		rightTitle = fmt.Sprintf(" %s ", m.diffview.filePath)
+		rightTitle = m.diffview.filePath
+	} else if !m.diffview.loading {
+		rightTitle = "Summary"
+		rightContent = m.filetree.Summary(rightWidth, contentHeight)
+	}
+	if m.diffOpts.IgnoreWhitespace {
+		rightTitle += " [whitespace ignored]"
 	}
 	rightStyle := unfocusedBorder
 	if m.focus == RightPanel {
@@ -213,32 +750,95 @@ func (m Model) View() string {
 	rightPanel := rightStyle.
 		Width(rightWidth).
 		Height(contentHeight).
-		Render(m.diffview.View())
+		Render(rightContent)
 
 	// Add titles to border tops
-	_ = leftTitle
-	_ = rightTitle
+	leftPanel = withBorderTitle(leftPanel, leftTitle, leftStyle)
+	rightPanel = withBorderTitle(rightPanel, rightTitle, rightStyle)
 
-	// Join panels horizontally
+	// Join panels horizontally, or show just the focused one when narrow
 	content := lipgloss.JoinHorizontal(lipgloss.Top, leftPanel, rightPanel)
+	if m.singlePane() {
+		content = leftPanel
+		if m.focus == RightPanel {
+			content = rightPanel
+		}
+	}
 
 	// Status bar
 	statusStyle := lipgloss.NewStyle().
 		Faint(true).
 		PaddingLeft(1)
+	diffMode := fmt.Sprintf("ctx:%d", m.diffOpts.Context)
+	if m.diffOpts.IgnoreWhitespace {
+		diffMode += " -w"
+	}
 	focusName := "file tree"
 	if m.focus == RightPanel {
 		focusName = "diff view"
 	}
 	repoCount := len(m.repos)
 	status := statusStyle.Render(
-		fmt.Sprintf("%d repo(s) | focus: %s | tab:switch  r:refresh  q:quit",
-			repoCount, focusName))
+		fmt.Sprintf("%d repo(s) | focus: %s | %s | %s", repoCount, focusName, diffMode, m.keyHints()))
+	if len(m.pending) > 0 {
+		// --coalesce is holding changes back; say they were noticed
+		status = statusStyle.Render("⟳ changes detected… |") + status
+	}
+	if m.scanning > 0 {
+		status = statusStyle.Render(m.spinner.View()+" scanning |") + status
+	}
+	if m.paused {
+		status = statusStyle.Bold(true).Foreground(m.theme.Warning).Render("PAUSED |") + status
+	}
+
+	// Show a pending numeric prefix like vim does
+	count := m.filetree.count
+	if m.focus == RightPanel {
+		count = m.diffview.count
+	}
+	if count > 0 {
+		status += statusStyle.Render(fmt.Sprintf("  %d", count))
+	}
+	if repo, err := m.filetree.CursorRepoErr(); err != nil {
+		status = statusStyle.
+			Foreground(m.theme.Error).
+			Render(repo.Name + ": " + err.Error())
+	}
+	if m.statusInfo != "" {
+		status = statusStyle.Render(m.statusInfo)
+	}
+	if m.statusErr != nil {
+		status = statusStyle.
+			Foreground(m.theme.Error).
+			Render("Error: " + m.statusErr.Error())
+	}
 
 	return content + "\n" + truncateToWidth(status, m.width)
 }
 
-// truncateToWidth cuts a string to fit within the given width.
+// withBorderTitle splices title into the top border of box, which was rendered
+// with style, keeping the corners and border color. Titles too long for the
+// border are shortened from the left so the file name stays visible.
+func withBorderTitle(box, title string, style lipgloss.Style) string {
+	lines := strings.Split(box, "\n")
+	width := lipgloss.Width(lines[0])
+	avail := width - 5 // both corners, one border segment, and a space either side of the title
+	if avail <= 0 {
+		return box
+	}
+	title = " " + truncateLeft(title, avail) + " "
+
+	border := style.GetBorderStyle()
+	borderStyle := lipgloss.NewStyle().Foreground(style.GetBorderTopForeground())
+	fill := width - 3 - lipgloss.Width(title)
+	lines[0] = borderStyle.Render(border.TopLeft+border.Top) +
+		borderStyle.Bold(true).Render(title) +
+		borderStyle.Render(strings.Repeat(border.Top, fill)+border.TopRight)
+	return strings.Join(lines, "\n")
+}
+
+// truncateToWidth cuts each line of a string to fit within the given width
+// without splitting characters or ANSI escape sequences.
 func truncateToWidth(s string, width int) string {
 	if width <= 0 {
 		return s
@@ -246,8 +846,137 @@ func truncateToWidth(s string, width int) string {
 	lines := strings.Split(s, "\n")
 	for i, line := range lines {
 		if lipgloss.Width(line) > width {
-			lines[i] = line[:width]
+			lines[i] = truncateAnsi(line, width)
 		}
 	}
 	return strings.Join(lines, "\n")
 }
+
+// keyHints lists the keys most useful in the current panel and mode for the
+// status bar, e.g. hunk navigation in the diff view or how to leave the filter.
+func (m Model) keyHints() string {
+	// A hint leaves out unbound actions, and is "" if none are bound
+	hint := func(label string, actions ...Action) string {
+		var keys []string
+		for _, action := range actions {
+			if key := m.keys.Help(action); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			return ""
+		}
+		return strings.Join(keys, "/") + ":" + label
+	}
+	var hints []string
+	switch {
+	case m.picker != nil:
+		return "enter:pick  esc:cancel"
+	case m.filetree.filtering:
+		return "type to filter  enter:keep filter  esc:clear"
+	case m.focus == RightPanel:
+		hints = []string{
+			hint("hunk", ActionNextHunk, ActionPrevHunk),
+			hint("top/bottom", ActionTop, ActionBottom),
+			hint("stage hunk", ActionStageHunk),
+			hint("context", ActionMoreContext, ActionLessContext),
+			hint("whitespace", ActionToggleWhitespace),
+			hint("added/removed", ActionFilterLines),
+			hint("edit", ActionOpenEditor),
+		}
+	default:
+		hints = []string{
+			hint("filter", ActionFilter),
+			hint("select", ActionSelect),
+			hint("collapse", ActionToggleCollapse),
+			hint("pin", ActionPin),
+			hint("repo", ActionNextRepo, ActionPrevRepo),
+			hint("reveal", ActionReveal),
+			hint("legend", ActionToggleLegend),
+		}
+	}
+	hints = append(hints, hint("switch", ActionSwitchPanel), hint("refresh", ActionRefresh), hint("quit", ActionQuit))
+	var shown []string
+	for _, h := range hints {
+		if h != "" {
+			shown = append(shown, h)
+		}
+	}
+	return strings.Join(shown, "  ")
+}
+
+// Session captures the state worth restoring with --resume.
+func (m Model) Session() *Session {
+	s := &Session{
+		Profiles:         m.source.profiles,
+		DiffFocused:      m.focus == RightPanel,
+		Zoomed:           m.zoomed,
+		Flat:             m.filetree.flat,
+		NoUntracked:      m.filetree.noUntracked,
+		Legend:           m.filetree.legend,
+		Absolute:         m.filetree.absolute,
+		Context:          m.diffOpts.Context,
+		IgnoreWhitespace: m.diffOpts.IgnoreWhitespace,
+	}
+	// Relative paths would resolve against wherever diffwatch is resumed from
+	for _, p := range m.source.paths {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		s.Paths = append(s.Paths, p)
+	}
+	if sel := m.filetree.selected; sel != nil {
+		s.SelectedRepo, s.SelectedFile = sel.Repo.WatchPath, sel.Path
+	}
+	for key := range m.filetree.manual {
+		if s.Collapsed == nil {
+			s.Collapsed = make(map[string]bool)
+		}
+		s.Collapsed[key] = m.filetree.collapsed[key]
+	}
+	for key := range m.filetree.pinned {
+		s.Pinned = append(s.Pinned, key)
+	}
+	sort.Strings(s.Pinned)
+	return s
+}
+
+// restoreSession applies a session saved by an earlier run. Its selected file
+// is selected once the initial scan of its repo comes in.
+func (m *Model) restoreSession(s *Session) {
+	if s.DiffFocused {
+		m.focus = RightPanel
+	}
+	m.zoomed = s.Zoomed
+	m.filetree.flat = s.Flat
+	m.filetree.noUntracked = s.NoUntracked
+	m.filetree.legend = s.Legend
+	m.filetree.absolute = s.Absolute
+	for key, collapsed := range s.Collapsed {
+		m.filetree.collapsed[key] = collapsed
+		m.filetree.manual[key] = true
+	}
+	for _, key := range s.Pinned {
+		m.filetree.pinned[key] = true
+	}
+	m.diffOpts.Context = s.Context
+	m.diffOpts.IgnoreWhitespace = s.IgnoreWhitespace
+	if s.SelectedFile != "" {
+		m.resume = s
+	}
+}
+
+// reopenFile selects the file last viewed in s, from a run without --resume,
+// once its repo is scanned, if that repo is watched now and the file still
+// has changes. Otherwise the first file is selected as usual.
+func (m *Model) reopenFile(s *Session) {
+	if s.SelectedFile == "" {
+		return
+	}
+	for _, repo := range m.repos {
+		if repo.WatchPath == s.SelectedRepo {
+			m.resume = &Session{SelectedRepo: s.SelectedRepo, SelectedFile: s.SelectedFile}
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
+)
+
+// profilePicker lists saved profiles so the user can switch to one. It takes
+// over the file tree panel while open.
+type profilePicker struct {
+	names   []string
+	cursor  int
+	current string // profile being watched, marked in the list
+}
+
+// newProfilePicker loads the saved profile names, with the cursor on current.
+func newProfilePicker(current string) (*profilePicker, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not load config: %w", err)
+	}
+	if len(cfg.Profiles) == 0 {
+		return nil, fmt.Errorf("no saved profiles; use --save <name> <path>... to create one")
+	}
+	p := &profilePicker{current: current}
+	for name := range cfg.Profiles {
+		p.names = append(p.names, name)
+	}
+	sort.Strings(p.names)
+	for i, name := range p.names {
+		if name == current {
+			p.cursor = i
+		}
+	}
+	return p, nil
+}
+
+// View renders the profile list, height rows at most.
+func (p *profilePicker) View(height int) string {
+	selectedStyle := lipgloss.NewStyle().Reverse(true)
+	start := max(p.cursor-height+1, 0)
+	var lines []string
+	for i := start; i < len(p.names) && len(lines) < height; i++ {
+		marker := "  "
+		if p.names[i] == p.current {
+			marker = "* "
+		}
+		line := marker + p.names[i]
+		if i == p.cursor {
+			line = selectedStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// profileSwitchedMsg carries the repos discovered for a newly chosen profile.
+type profileSwitchedMsg struct {
+	source   repoSource
+	repos    []diffwatch.Repo
+	prefix   string // stripped from the repo names, see discoverAll
+	problems []string
+	err      error
+}
+
+// switchProfile returns a tea.Cmd that resolves profile and discovers its repos.
+func switchProfile(profile string, opts Options) tea.Cmd {
+	return func() tea.Msg {
+		source := repoSource{profiles: []string{profile}}
+		paths, err := source.resolve()
+		if err != nil {
+			return profileSwitchedMsg{err: err}
+		}
+		repos, prefix, problems := discoverAll(paths, opts, false)
+		return profileSwitchedMsg{source: source, repos: repos, prefix: prefix, problems: problems}
+	}
+}
@@ -0,0 +1,17 @@
+// Package diffwatch discovers git repositories and watches them for
+// uncommitted changes. It is the core of the diffwatch TUI and can be used
+// on its own by editor plugins, CI tools, or other programs:
+//
+//	repos, err := diffwatch.DiscoverRepos(".", diffwatch.DiscoverOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	w, err := diffwatch.NewWatcher(ctx, repos, diffwatch.StatusOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	defer w.Close()
+//	for change := range w.Changes() {
+//		fmt.Println(change.Repo.Name, len(change.Files))
+//	}
+package diffwatch
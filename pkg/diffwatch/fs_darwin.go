@@ -0,0 +1,35 @@
+package diffwatch
+
+import "syscall"
+
+// remoteFilesystems lists the names statfs reports for network filesystems.
+var remoteFilesystems = map[string]bool{
+	"nfs":     true,
+	"smbfs":   true,
+	"afpfs":   true,
+	"webdav":  true,
+	"cifs":    true,
+	"macfuse": true,
+	"osxfuse": true,
+}
+
+// RemoteFilesystem returns the type of network filesystem path is on, e.g.
+// "nfs", or "" if it is local or can't be determined. git status on a network
+// filesystem can take seconds, which slows polling for every repo.
+func RemoteFilesystem(path string) string {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return ""
+	}
+	var name []byte
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	if remoteFilesystems[string(name)] {
+		return string(name)
+	}
+	return ""
+}
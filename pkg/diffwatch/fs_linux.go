@@ -0,0 +1,29 @@
+package diffwatch
+
+import "syscall"
+
+// remoteFilesystems maps the statfs magic numbers of network and FUSE
+// filesystems to their names.
+var remoteFilesystems = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xfe534d42: "smb2",
+	0xff534d42: "cifs",
+	0x5346414f: "afs",
+	0x01021997: "9p",
+	0x73757245: "coda",
+	0x65735546: "fuse", // sshfs, rclone and other userspace filesystems
+	0x0bd00bd0: "lustre",
+	0x47504653: "gpfs",
+}
+
+// RemoteFilesystem returns the type of network filesystem path is on, e.g.
+// "nfs", or "" if it is local or can't be determined. git status on a network
+// filesystem can take seconds, which slows polling for every repo.
+func RemoteFilesystem(path string) string {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return ""
+	}
+	return remoteFilesystems[uint32(st.Type)]
+}
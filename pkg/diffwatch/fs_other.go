@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package diffwatch
+
+// RemoteFilesystem returns the type of network filesystem path is on, or ""
+// if it is local. Detection isn't supported on this platform, so it always
+// returns "".
+func RemoteFilesystem(path string) string {
+	return ""
+}
@@ -0,0 +1,1437 @@
+package diffwatch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Repo represents a single git repository.
+type Repo struct {
+	Name      string // display name (relative path from discovery root, e.g. "shopify/billing")
+	Path      string // absolute path to repo root
+	WatchPath string // absolute path to the subtree or single file to watch (may equal Path)
+
+	// Plain marks a directory watched without git, see DiscoverOptions.NoGit.
+	// Its files are compared to a snapshot taken at discovery.
+	Plain bool
+}
+
+// ChangedFile represents a file with uncommitted changes.
+type ChangedFile struct {
+	Repo     *Repo
+	Path     string // relative to repo root
+	OrigPath string // for renames and copies, the path it was renamed or copied from
+	Status   string // M, A, D, R, ?, ! (ignored), U (conflict), etc.
+	XY       string // git's porcelain status pair: the index column, then the worktree column
+	Base     string // commit the file is diffed against instead of the index or HEAD, see StatusOptions.MergeBase
+	Side     string // "staged" or "unstaged" to diff only those changes, see StatusOptions.StagedOnly; "" for both
+
+	// Mode describes a file mode change against HEAD as "old → new", e.g.
+	// "100644 → 100755", or is empty if the mode is unchanged.
+	Mode     string
+	ModeOnly bool // the mode changed but the content didn't
+
+	// Added and Removed count the file's changed lines against HEAD, or the
+	// merge base. Both are 0 for untracked and binary files.
+	Added, Removed int
+
+	LFS bool // stored with Git LFS, so its diff is of a pointer file, not the content
+
+	// DiffHash identifies the file's change, see StatusOptions.HashDiffs. Files
+	// with the same path and DiffHash in different repos have the same change.
+	DiffHash string
+}
+
+// Staged reports whether the file has changes in the index.
+func (f ChangedFile) Staged() bool {
+	return len(f.XY) == 2 && f.Status != "U" && f.XY[0] != ' ' && f.XY[0] != '?' && f.XY[0] != '!'
+}
+
+// Unstaged reports whether the file has changes in the working tree that
+// aren't in the index. Untracked and ignored files count as unstaged.
+func (f ChangedFile) Unstaged() bool {
+	return len(f.XY) == 2 && f.Status != "U" && f.XY[1] != ' '
+}
+
+// DiscoverOptions controls how DiscoverRepos searches for repositories.
+type DiscoverOptions struct {
+	FollowSymlinks bool // descend into symlinked directories, skipping any already visited
+	Submodules     bool // also return each initialized submodule as its own repo
+	MaxRepos       int  // stop walking once this many repos are found; 0 means no limit
+	MaxDepth       int  // don't look more than this many directories below root; 0 means no limit
+
+	// NameTemplate, if set, replaces each repo's display name. It may use
+	// {name} (the default name), {rel} (the path relative to root), {base}
+	// (the last path element) and {parent} (the one before it), e.g.
+	// "{parent}/{base}". Worktree branches and submodule paths are still
+	// appended to the result.
+	NameTemplate string
+
+	// Progress, if set, is called with the number of repos found so far each
+	// time the walk down from root finds another one.
+	Progress func(found int)
+
+	// NoGit watches root as a plain directory instead of looking for repos:
+	// a snapshot of its files is taken now, and GetRepoStatus reports files
+	// created, modified, or deleted since. Call RemoveSnapshots on exit.
+	NoGit bool
+}
+
+// ErrRepoLimit is returned (wrapped) by DiscoverRepos along with the repos found
+// so far when the walk stops at DiscoverOptions.MaxRepos.
+var ErrRepoLimit = errors.New("repo limit reached")
+
+// DiscoverRepos finds git repos starting from root. If root is inside a git repo
+// (or is one), it returns that repo with WatchPath scoped to root; root may be a
+// single file, which scopes the watch to just that file. Otherwise it walks down
+// looking for repos. With opts.Submodules, each repo is followed by its
+// initialized submodules. Worktrees of the same repository found together are
+// named with their branch, e.g. "app@main", to tell them apart.
+func DiscoverRepos(root string, opts DiscoverOptions) ([]Repo, error) {
+	if opts.NoGit {
+		return discoverPlain(root)
+	}
+	repos, err := discoverRepos(root, opts)
+	if err != nil && !errors.Is(err, ErrRepoLimit) {
+		return nil, err
+	}
+	if opts.NameTemplate != "" {
+		applyNameTemplate(repos, root, opts.NameTemplate)
+	}
+	nameWorktrees(repos)
+	if !opts.Submodules {
+		return repos, err
+	}
+	var all []Repo
+	for _, repo := range repos {
+		all = append(all, repo)
+		all = append(all, submoduleRepos(repo)...)
+	}
+	return all, err
+}
+
+// discoverPlain snapshots root for watching without git.
+func discoverPlain(root string) ([]Repo, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(absRoot); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory; without git only directories can be watched", root)
+	}
+	if err := takeSnapshot(absRoot); err != nil {
+		return nil, err
+	}
+	return []Repo{{Name: filepath.Base(absRoot), Path: absRoot, WatchPath: absRoot, Plain: true}}, nil
+}
+
+// applyNameTemplate renames repos found under root using template, as
+// described for DiscoverOptions.NameTemplate.
+func applyNameTemplate(repos []Repo, root, template string) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = root
+	}
+	for i := range repos {
+		path := repos[i].WatchPath
+		base := filepath.Base(path)
+		rel, err := filepath.Rel(absRoot, path)
+		if err != nil || rel == "." {
+			rel = base
+		}
+		repos[i].Name = strings.NewReplacer(
+			"{name}", repos[i].Name,
+			"{rel}", filepath.ToSlash(rel),
+			"{base}", base,
+			"{parent}", filepath.Base(filepath.Dir(path)),
+		).Replace(template)
+	}
+}
+
+// discoverRepos finds the repos for root, not including submodules.
+func discoverRepos(root string, opts DiscoverOptions) ([]Repo, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []Repo
+
+	// A file is watched on its own, within whichever repo contains it
+	if info, err := os.Stat(absRoot); err == nil && !info.IsDir() {
+		repoRoot := findGitRoot(filepath.Dir(absRoot))
+		if repoRoot == "" {
+			return nil, fmt.Errorf("%s is not inside a git repository", root)
+		}
+		rel, _ := filepath.Rel(repoRoot, absRoot)
+		repos = append(repos, Repo{
+			Name:      filepath.Base(repoRoot) + "/" + filepath.ToSlash(rel),
+			Path:      repoRoot,
+			WatchPath: absRoot,
+		})
+		return repos, nil
+	}
+
+	// Check if root itself is a git repo
+	if isGitRepo(absRoot) {
+		repos = append(repos, Repo{
+			Name:      filepath.Base(absRoot),
+			Path:      absRoot,
+			WatchPath: absRoot,
+		})
+		return repos, nil
+	}
+
+	// Check if root is inside a git repo (walk up to find repo root)
+	if repoRoot := findGitRoot(absRoot); repoRoot != "" {
+		name := filepath.Base(repoRoot)
+		// Include the subdirectory in the display name
+		rel, _ := filepath.Rel(repoRoot, absRoot)
+		if rel != "." {
+			name = name + "/" + rel
+		}
+		repos = append(repos, Repo{
+			Name:      name,
+			Path:      repoRoot,
+			WatchPath: absRoot,
+		})
+		return repos, nil
+	}
+
+	// Walk down looking for repos
+	visited := make(map[string]bool) // real paths already walked, when following symlinks
+	limited := false
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if limited {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				return nil // skip directories we can't read
+			}
+			if d.Type()&os.ModeSymlink != 0 && opts.FollowSymlinks {
+				if info, statErr := os.Stat(path); statErr == nil && info.IsDir() {
+					// A trailing separator makes WalkDir descend into the link target
+					return walk(path + string(os.PathSeparator))
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if opts.MaxDepth > 0 && walkDepth(absRoot, path) > opts.MaxDepth {
+				return filepath.SkipDir
+			}
+			// Skip hidden directories (except .git which we check for)
+			if d.Name() != "." && strings.HasPrefix(d.Name(), ".") && path != absRoot {
+				return filepath.SkipDir
+			}
+			if opts.FollowSymlinks {
+				// Symlinks can form cycles, so never walk the same real directory twice
+				real, realErr := filepath.EvalSymlinks(path)
+				if realErr != nil || visited[real] {
+					return filepath.SkipDir
+				}
+				visited[real] = true
+			}
+
+			if isGitRepo(path) {
+				path = filepath.Clean(path)
+				rel, relErr := filepath.Rel(absRoot, path)
+				if relErr != nil {
+					rel = filepath.Base(path)
+				}
+				repos = append(repos, Repo{
+					Name:      rel,
+					Path:      path,
+					WatchPath: path,
+				})
+				if opts.Progress != nil {
+					opts.Progress(len(repos))
+				}
+				if opts.MaxRepos > 0 && len(repos) >= opts.MaxRepos {
+					limited = true
+					return filepath.SkipAll
+				}
+				return filepath.SkipDir // don't look for nested repos
+			}
+			return nil
+		})
+	}
+	if err := walk(absRoot); err != nil {
+		return nil, err
+	}
+	if limited {
+		return repos, fmt.Errorf("%w: stopped after %d repos", ErrRepoLimit, opts.MaxRepos)
+	}
+
+	return repos, nil
+}
+
+// walkDepth returns how many directory levels path is below root.
+func walkDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(os.PathSeparator)) + 1
+}
+
+// submoduleRepos returns the initialized submodules of parent, recursively, as
+// repos named "parent/submodule". Submodules outside parent's WatchPath are skipped.
+func submoduleRepos(parent Repo) []Repo {
+	out, err := gitCommand("-C", parent.Path, "config", "-f", ".gitmodules",
+		"-z", "--get-regexp", `^submodule\..*\.path$`).Output()
+	if err != nil {
+		return nil // no .gitmodules, or no submodules in it
+	}
+
+	var repos []Repo
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// Each entry is "submodule.<name>.path\n<path>"
+		_, rel, ok := strings.Cut(entry, "\n")
+		if !ok {
+			continue
+		}
+		path := filepath.Join(parent.Path, filepath.FromSlash(rel))
+		if !isGitRepo(path) {
+			continue // not initialized
+		}
+		if within, err := filepath.Rel(parent.WatchPath, path); err != nil || strings.HasPrefix(within, "..") {
+			continue
+		}
+		sub := Repo{
+			Name:      parent.Name + "/" + rel,
+			Path:      path,
+			WatchPath: path,
+		}
+		repos = append(repos, sub)
+		repos = append(repos, submoduleRepos(sub)...)
+	}
+	return repos
+}
+
+// IsSubmoduleOf reports whether repo is a submodule checked out inside
+// parent's working tree. A submodule's git directory lives under its parent's
+// .git/modules, which tells it apart from an unrelated repo that merely sits
+// in the parent's tree, e.g. an ignored clone.
+func IsSubmoduleOf(repo, parent Repo) bool {
+	rel, err := filepath.Rel(parent.Path, repo.Path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	modules := filepath.Join(gitDir(parent.Path), "modules") + string(filepath.Separator)
+	return strings.HasPrefix(gitDir(repo.Path), modules)
+}
+
+// nameWorktrees appends the checked-out branch to the names of repos that are
+// worktrees of the same repository, as listed by git worktree list.
+func nameWorktrees(repos []Repo) {
+	groups := make(map[string][]int) // common git dir -> indexes into repos
+	for i := range repos {
+		common := commonGitDir(repos[i].Path)
+		groups[common] = append(groups[common], i)
+	}
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		branches := worktreeBranches(repos[group[0]].Path)
+		for _, i := range group {
+			if branch, ok := branches[realPath(repos[i].Path)]; ok {
+				repos[i].Name += "@" + branch
+			}
+		}
+	}
+}
+
+// worktreeBranches runs git worktree list in repoPath and maps each worktree's
+// real path to its branch, or to its abbreviated commit when HEAD is detached.
+func worktreeBranches(repoPath string) map[string]string {
+	out, err := gitCommand("-C", repoPath, "worktree", "list", "--porcelain").Output()
+	if err != nil {
+		return nil
+	}
+	branches := make(map[string]string)
+	var path, head string
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			path = realPath(strings.TrimPrefix(line, "worktree "))
+		case strings.HasPrefix(line, "HEAD "):
+			head = strings.TrimPrefix(line, "HEAD ")
+			if len(head) > 7 {
+				branches[path] = head[:7]
+			}
+		case strings.HasPrefix(line, "branch "):
+			branches[path] = strings.TrimPrefix(strings.TrimPrefix(line, "branch "), "refs/heads/")
+		}
+	}
+	return branches
+}
+
+// commonGitDir returns the git directory shared by all worktrees of the repo at
+// repoPath. For a linked worktree this is the main repository's git dir, found
+// through the "commondir" file in the worktree's own git dir.
+func commonGitDir(repoPath string) string {
+	dir := gitDir(repoPath)
+	data, err := os.ReadFile(filepath.Join(dir, "commondir"))
+	if err != nil {
+		return realPath(dir)
+	}
+	common := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(dir, common)
+	}
+	return realPath(common)
+}
+
+// realPath resolves symlinks in path so equal directories compare equal,
+// falling back to the cleaned path.
+func realPath(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	return filepath.Clean(path)
+}
+
+// isGitRepo returns true if dir contains a .git entry (directory or worktree file).
+func isGitRepo(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// findGitRoot walks up from dir looking for a .git entry, returning the repo root
+// or "" if not found. Stops at filesystem root.
+func findGitRoot(dir string) string {
+	for {
+		if isGitRepo(dir) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "" // reached filesystem root
+		}
+		dir = parent
+	}
+}
+
+// gitDir returns the git directory for the repo at repoPath, following the
+// "gitdir:" pointer that worktrees and submodules use in place of a .git directory.
+func gitDir(repoPath string) string {
+	dotGit := filepath.Join(repoPath, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil || info.IsDir() {
+		return dotGit
+	}
+	data, err := os.ReadFile(dotGit)
+	if err != nil {
+		return dotGit
+	}
+	dir := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(data)), "gitdir:"))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(repoPath, dir)
+	}
+	return dir
+}
+
+// RepoState returns the in-progress operation for a repo, detected from marker
+// files in its git dir: "MERGING", "REBASING", "CHERRY-PICKING", "REVERTING",
+// "BISECTING", or "" when none is in progress.
+func RepoState(repo *Repo) string {
+	dir := gitDir(repo.Path)
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	switch {
+	case exists("rebase-merge"), exists("rebase-apply"):
+		return "REBASING"
+	case exists("MERGE_HEAD"):
+		return "MERGING"
+	case exists("CHERRY_PICK_HEAD"):
+		return "CHERRY-PICKING"
+	case exists("REVERT_HEAD"):
+		return "REVERTING"
+	case exists("BISECT_LOG"):
+		return "BISECTING"
+	default:
+		return ""
+	}
+}
+
+// ErrTimeout is returned (wrapped) when a git command runs past its time limit.
+var ErrTimeout = errors.New("timed out")
+
+// statusTimeout bounds each git status run so one stuck repo can't stall polling.
+const statusTimeout = 30 * time.Second
+
+// gitBinary is the git executable every command in the package runs.
+var gitBinary = "git"
+
+// SetGitBinary makes the package run path instead of the git on PATH. Call it
+// before discovering or watching repos; it isn't safe to change while
+// commands may be running.
+func SetGitBinary(path string) {
+	gitBinary = path
+}
+
+// gitCommand returns a command running git with args, for the calls that
+// don't need a timeout.
+func gitCommand(args ...string) *exec.Cmd {
+	return exec.Command(gitBinary, args...)
+}
+
+// runOutput runs a command and returns its stdout, killing it after timeout
+// (0 means no limit). WaitDelay stops us waiting on pipes held open by children
+// of a killed shell pipeline.
+func runOutput(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	return runOutputContext(context.Background(), timeout, name, args...)
+}
+
+// runOutputContext is runOutput, but also kills the command when parent is
+// canceled, returning parent's error.
+func runOutputContext(parent context.Context, timeout time.Duration, name string, args ...string) ([]byte, error) {
+	cmd, ctx, cancel := timedCommand(parent, timeout, name, args...)
+	defer cancel()
+	out, err := cmd.Output()
+	if parent.Err() != nil {
+		return out, parent.Err()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return out, fmt.Errorf("%w after %s", ErrTimeout, timeout)
+	}
+	return out, err
+}
+
+// runOutputStderr is runOutput, but also returns stderr, which runOutput only
+// keeps for commands that fail.
+func runOutputStderr(timeout time.Duration, name string, args ...string) (stdout, stderr []byte, err error) {
+	cmd, ctx, cancel := timedCommand(context.Background(), timeout, name, args...)
+	defer cancel()
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %s", ErrTimeout, timeout)
+	}
+	return outBuf.Bytes(), errBuf.Bytes(), err
+}
+
+// timedCommand returns a command that is killed after timeout (0 means no
+// limit) or when parent is canceled, with the context to check for the
+// deadline and its cancel func.
+func timedCommand(parent context.Context, timeout time.Duration, name string, args ...string) (*exec.Cmd, context.Context, context.CancelFunc) {
+	ctx, cancel := parent, context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = time.Second
+	return cmd, ctx, cancel
+}
+
+// StatusOptions trades the fidelity of GetChangedFiles for speed on very large repos.
+type StatusOptions struct {
+	NoRenames bool // skip rename detection; a renamed file shows as a delete plus an add
+	// UntrackedDirs lists a new directory as one "dir/" entry instead of every
+	// file in it, so git doesn't have to walk untracked trees.
+	UntrackedDirs bool
+
+	Ignored bool // also list files ignored by .gitignore, with status "!"
+
+	// MergeBase, if set, names a branch; files are then listed and diffed
+	// against the point HEAD forked from it (git merge-base HEAD <branch>),
+	// so commits on the current branch count as changes too, like a pull
+	// request.
+	MergeBase string
+
+	// StagedOnly lists only files with staged changes, and UnstagedOnly only
+	// those with changes in the working tree, untracked files included; their
+	// diffs then show just that side. Conflicted files are listed either way.
+	StagedOnly   bool
+	UnstagedOnly bool
+
+	// HashDiffs fills in ChangedFile.DiffHash, at the cost of a git diff of
+	// the whole repo on each status.
+	HashDiffs bool
+}
+
+// RepoStatus is what one git status run reports about a repo.
+type RepoStatus struct {
+	Files  []ChangedFile
+	Ahead  int // commits on HEAD that its upstream branch doesn't have; 0 without an upstream
+	Behind int // commits on the upstream branch that HEAD doesn't have
+}
+
+// GetChangedFiles runs `git status --porcelain` and returns changed files for a repo.
+// When WatchPath is a subdirectory of (or a file in) the repo, only files under that path are returned.
+// Files matching the repo's .diffwatchignore are left out.
+func GetChangedFiles(repo *Repo, opts StatusOptions) ([]ChangedFile, error) {
+	status, err := GetRepoStatus(repo, opts)
+	return status.Files, err
+}
+
+// GetRepoStatus is GetChangedFiles, plus how far the current branch is ahead
+// of and behind its upstream, which git status reports in the same run.
+func GetRepoStatus(repo *Repo, opts StatusOptions) (RepoStatus, error) {
+	return GetRepoStatusContext(context.Background(), repo, opts)
+}
+
+// GetRepoStatusContext is GetRepoStatus, stopping early with ctx's error when
+// ctx is canceled or its deadline passes. git status itself is killed; the
+// lookups after it finish first.
+func GetRepoStatusContext(ctx context.Context, repo *Repo, opts StatusOptions) (RepoStatus, error) {
+	return getRepoStatus(ctx, repo, opts, false)
+}
+
+// getRepoStatus is GetRepoStatusContext. With refresh, line counts, modes and
+// hashes are worked out again even if the cache says nothing changed.
+func getRepoStatus(ctx context.Context, repo *Repo, opts StatusOptions, refresh bool) (RepoStatus, error) {
+	if repo.Plain {
+		files, err := plainStatus(repo)
+		return RepoStatus{Files: files}, err
+	}
+	// -z leaves paths unquoted, so names with spaces or non-ASCII characters
+	// come through as they are on disk
+	args := []string{"-C", repo.Path, "--no-optional-locks", "status", "--porcelain", "--branch", "-z"}
+	if opts.UntrackedDirs {
+		args = append(args, "--untracked-files=normal")
+	} else {
+		args = append(args, "--untracked-files=all")
+	}
+	if opts.NoRenames {
+		args = append(args, "--no-renames")
+	}
+	if opts.Ignored {
+		args = append(args, "--ignored")
+	}
+	// Scope git status to the watch subtree for large repos
+	if repo.WatchPath != repo.Path {
+		rel, err := filepath.Rel(repo.Path, repo.WatchPath)
+		if err == nil {
+			args = append(args, "--", rel)
+		}
+	}
+	out, err := runOutputContext(ctx, statusTimeout, gitBinary, args...)
+	if ctx.Err() != nil {
+		return RepoStatus{}, ctx.Err()
+	}
+	if errors.Is(err, ErrTimeout) {
+		return RepoStatus{}, fmt.Errorf("git status %w", err)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		// git's own message, e.g. "fatal: index file corrupt", says more than the exit status
+		return RepoStatus{}, errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	var status RepoStatus
+	ignore := loadIgnorePatterns(repo.Path)
+	var files []ChangedFile
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if strings.HasPrefix(entry, "## ") {
+			status.Ahead, status.Behind = parseBranchHeader(entry)
+			continue
+		}
+		if len(entry) < 4 {
+			continue
+		}
+
+		// Porcelain format: XY PATH
+		// X = index status, Y = worktree status
+		// We use the most meaningful status character.
+		xy := entry[:2]
+		path := entry[3:]
+
+		// Renames and copies are followed by the original path as its own entry
+		var origPath string
+		if strings.ContainsAny(xy, "RC") && i+1 < len(entries) {
+			i++
+			origPath = entries[i]
+		}
+
+		if isIgnored(ignore, path) {
+			continue
+		}
+
+		code := parseStatus(xy)
+		files = append(files, ChangedFile{
+			Repo:     repo,
+			Path:     path,
+			OrigPath: origPath,
+			Status:   code,
+			XY:       xy,
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	// Only tracked files are diffed against the base, so finding it, which
+	// takes a git run or two, is skipped while every change is untracked
+	tracked := hasTracked(files)
+	var base, commit string
+	if tracked || opts.MergeBase != "" {
+		base, commit = diffBaseCommit(ctx, repo.Path)
+	}
+	if opts.MergeBase != "" {
+		if base, err = mergeBase(ctx, repo, opts.MergeBase); err != nil {
+			return RepoStatus{}, err
+		}
+		if files, err = mergeBaseFiles(ctx, repo, base, files, ignore, opts); err != nil {
+			return RepoStatus{}, err
+		}
+	}
+	if ctx.Err() != nil {
+		return RepoStatus{}, ctx.Err()
+	}
+
+	if opts.StagedOnly || opts.UnstagedOnly {
+		files = filterSide(files, opts.StagedOnly)
+	}
+
+	// Line counts, mode changes and hashes take a git diff each, so they're
+	// only worked out again when the status or a file has changed
+	key := statsKey(repo, out, base, commit, files, opts)
+	if refresh || !cachedStats(repo, key, files) {
+		// git diff only knows about tracked files, so skip it when only
+		// untracked ones changed
+		if hasTracked(files) {
+			applyDiffStats(ctx, repo, files, base)
+		}
+		if opts.HashDiffs {
+			applyDiffHashes(ctx, repo, files, base)
+		}
+		if ctx.Err() != nil {
+			// Stats cut short by the cancel would be cached as if complete
+			return RepoStatus{}, ctx.Err()
+		}
+		storeStats(repo, key, files)
+	}
+	applyLFS(ctx, repo, files)
+	if ctx.Err() != nil {
+		return RepoStatus{}, ctx.Err()
+	}
+
+	status.Files = files
+	return status, nil
+}
+
+// hasTracked reports whether any of files is a change to a tracked file,
+// which git diff can compare against the base.
+func hasTracked(files []ChangedFile) bool {
+	for _, f := range files {
+		if strings.Contains("MADRC", f.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBranchHeader reads the ahead and behind counts from git status's
+// "## main...origin/main [ahead 2, behind 1]" line. Either count is left out
+// when it's 0, and both are when there is no upstream.
+func parseBranchHeader(header string) (ahead, behind int) {
+	start := strings.LastIndex(header, " [")
+	if start < 0 || !strings.HasSuffix(header, "]") {
+		return 0, 0
+	}
+	for _, part := range strings.Split(header[start+2:len(header)-1], ", ") {
+		if n, ok := strings.CutPrefix(part, "ahead "); ok {
+			ahead, _ = strconv.Atoi(n)
+		} else if n, ok := strings.CutPrefix(part, "behind "); ok {
+			behind, _ = strconv.Atoi(n)
+		}
+	}
+	return ahead, behind
+}
+
+// filterSide keeps the files with staged changes, or with unstaged ones if
+// staged is false, and marks them to diff only that side. Conflicts are kept
+// as they are.
+func filterSide(files []ChangedFile, staged bool) []ChangedFile {
+	side := "unstaged"
+	if staged {
+		side = "staged"
+	}
+	kept := files[:0]
+	for _, f := range files {
+		switch {
+		case f.Status == "U":
+			kept = append(kept, f)
+		case staged && f.Staged(), !staged && f.Unstaged():
+			f.Side = side
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// mergeBase returns the commit where HEAD forked from branch.
+func mergeBase(ctx context.Context, repo *Repo, branch string) (string, error) {
+	if _, err := runOutputContext(ctx, statusTimeout, gitBinary, "-C", repo.Path, "rev-parse", "--verify", "--quiet", branch+"^{commit}"); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("no branch %q to find the merge base with", branch)
+	}
+	out, err := runOutputContext(ctx, statusTimeout, gitBinary, "-C", repo.Path, "merge-base", "HEAD", branch)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", fmt.Errorf("HEAD has no common ancestor with %s", branch)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// mergeBaseFiles lists the files that differ between base and the working
+// tree, scoped and filtered like GetChangedFiles. Entries from statusFiles
+// lend the listed files their XY, and untracked, ignored and conflicted ones,
+// which git diff doesn't report, are kept as they are.
+func mergeBaseFiles(ctx context.Context, repo *Repo, base string, statusFiles []ChangedFile, ignore []ignorePattern, opts StatusOptions) ([]ChangedFile, error) {
+	args := []string{"-C", repo.Path, "--no-optional-locks", "diff", "--name-status", "-z"}
+	if opts.NoRenames {
+		args = append(args, "--no-renames")
+	} else {
+		args = append(args, "-M")
+	}
+	args = append(args, base)
+	if repo.WatchPath != repo.Path {
+		if rel, err := filepath.Rel(repo.Path, repo.WatchPath); err == nil {
+			args = append(args, "--", rel)
+		}
+	}
+	out, err := runOutputContext(ctx, statusTimeout, gitBinary, args...)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, ErrTimeout) {
+		return nil, fmt.Errorf("git diff %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	xy := make(map[string]string, len(statusFiles))
+	for _, f := range statusFiles {
+		xy[f.Path] = f.XY
+	}
+	var files []ChangedFile
+	listed := make(map[string]bool)
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		// "R100" NUL old NUL new for renames and copies, "M" NUL path otherwise
+		status, path := fields[i][:1], fields[i+1]
+		var origPath string
+		if (status == "R" || status == "C") && i+2 < len(fields) {
+			origPath, path = path, fields[i+2]
+			i++
+		}
+		if status == "T" {
+			status = "M" // a type change, e.g. file to symlink
+		}
+		if isIgnored(ignore, path) {
+			continue
+		}
+		listed[path] = true
+		files = append(files, ChangedFile{
+			Repo:     repo,
+			Path:     path,
+			OrigPath: origPath,
+			Status:   status,
+			XY:       xy[path],
+			Base:     base,
+		})
+	}
+	for _, f := range statusFiles {
+		if !listed[f.Path] && (f.Status == "?" || f.Status == "!" || f.Status == "U") {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// applyDiffStats fills in Added and Removed for files changed against base,
+// and Mode and ModeOnly for those whose mode differs. Errors are ignored; the
+// files are then reported without line counts or mode information, as in a
+// repo with no commits yet.
+func applyDiffStats(ctx context.Context, repo *Repo, files []ChangedFile, base string) {
+	args := []string{"-C", repo.Path, "--no-optional-locks", "diff", base, "--raw", "--numstat", "--no-renames", "-z"}
+	if repo.WatchPath != repo.Path {
+		if rel, err := filepath.Rel(repo.Path, repo.WatchPath); err == nil {
+			args = append(args, "--", rel)
+		}
+	}
+	out, err := runOutputContext(ctx, statusTimeout, gitBinary, args...)
+	if err != nil {
+		return
+	}
+
+	modes, counts := parseRawNumstat(string(out))
+	for i := range files {
+		count, counted := counts[files[i].Path]
+		files[i].Added, files[i].Removed = count.added, count.removed
+		if mode, ok := modes[files[i].Path]; ok {
+			files[i].Mode = mode
+			files[i].ModeOnly = counted && !count.binary && count.added == 0 && count.removed == 0
+		}
+	}
+}
+
+// lineCount is one path's entry in git diff --numstat.
+type lineCount struct {
+	added, removed int
+	binary         bool // numstat shows "-" for both counts; they're left 0
+}
+
+// parseRawNumstat parses the output of git diff --raw --numstat -z. It returns
+// the mode change of each path whose mode differs, formatted as "old → new",
+// and the added and removed line counts of each path.
+func parseRawNumstat(out string) (modes map[string]string, counts map[string]lineCount) {
+	modes = make(map[string]string)
+	counts = make(map[string]lineCount)
+	fields := strings.Split(out, "\x00")
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if strings.HasPrefix(field, ":") {
+			// Raw: ":old new oldsha newsha status" NUL path
+			parts := strings.Fields(field[1:])
+			if i+1 >= len(fields) || len(parts) < 2 {
+				break
+			}
+			i++
+			oldMode, newMode := parts[0], parts[1]
+			if oldMode != newMode && oldMode != "000000" && newMode != "000000" {
+				modes[fields[i]] = oldMode + " → " + newMode
+			}
+			continue
+		}
+		// Numstat: "added<TAB>deleted<TAB>path"
+		parts := strings.SplitN(field, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "-" && parts[1] == "-" {
+			counts[parts[2]] = lineCount{binary: true}
+			continue
+		}
+		added, _ := strconv.Atoi(parts[0])
+		removed, _ := strconv.Atoi(parts[1])
+		counts[parts[2]] = lineCount{added: added, removed: removed}
+	}
+	return modes, counts
+}
+
+// parseStatus converts the two-character porcelain status to a single display character.
+func parseStatus(xy string) string {
+	x := xy[0] // index (staged) status
+	y := xy[1] // worktree status
+
+	switch {
+	case isUnmerged(x, y):
+		return "U"
+	case x == '?' || y == '?':
+		return "?"
+	case x == '!':
+		return "!"
+	case x == 'A' || y == 'A':
+		return "A"
+	case x == 'D' || y == 'D':
+		return "D"
+	case x == 'R' || y == 'R':
+		return "R"
+	case x == 'M' || y == 'M':
+		return "M"
+	case x == 'C' || y == 'C':
+		return "C"
+	default:
+		return string(x)
+	}
+}
+
+// isUnmerged reports whether a porcelain XY pair is one of git's unmerged
+// (conflicted) states: DD, AU, UD, UA, DU, AA, or UU.
+func isUnmerged(x, y byte) bool {
+	return x == 'U' || y == 'U' || (x == 'A' && y == 'A') || (x == 'D' && y == 'D')
+}
+
+// DiffOptions controls how diffs are generated and rendered.
+type DiffOptions struct {
+	Pager            string        // rendering command, e.g. "delta"; empty shows git's own colored output
+	Context          int           // lines of context around each change (git diff -U<n>)
+	IgnoreWhitespace bool          // hide whitespace-only changes (git diff -w)
+	Timeout          time.Duration // give up on git and the pager after this long; 0 waits indefinitely
+	TabWidth         int           // columns per tab in delta's output (delta --tabs); 0 keeps delta's default
+
+	// MaxLineLength cuts diff lines longer than this many characters, like a
+	// minified bundle's single line, so one can't bog down the view; 0 keeps
+	// every line whole.
+	MaxLineLength int
+}
+
+// deltaFlags are the flags delta needs to emit colored, non-paged output that fits the diff panel.
+const deltaFlags = "--paging=never --color-only --line-numbers --file-style=omit --hunk-header-style=omit"
+
+// GetDiff runs git diff piped through the configured pager and returns the ANSI-colored output.
+// For untracked and ignored files, it uses git diff --no-index to generate a diff.
+func GetDiff(file ChangedFile, opts DiffOptions) (string, error) {
+	if file.LFS {
+		return withModeChange(file, lfsNote(file)), nil
+	}
+	out, stderr, err := runOutputStderr(opts.Timeout, "bash", "-c", DiffShellCommand(file, opts))
+	if errors.Is(err, ErrTimeout) {
+		return "", fmt.Errorf("git diff %w", err)
+	}
+	// Warnings from git or the pager, e.g. a typo in delta's config, would
+	// otherwise be lost; show them when there is no diff to show instead
+	message := strings.TrimSpace(string(stderr))
+	if err != nil {
+		// git diff --no-index returns exit code 1 when files differ, which is expected
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			if message != "" {
+				return "", errors.New(message)
+			}
+			return "", err
+		}
+	}
+	if message != "" && strings.TrimSpace(stripAnsi(string(out))) == "" {
+		return "", errors.New(message)
+	}
+
+	diff, notes := normalizeText(string(out))
+	diff = stripDiffHeader(diff)
+	diff, cut := truncateLongLines(diff, opts.MaxLineLength)
+	if cut > 0 {
+		notes = append(notes, fmt.Sprintf("%d long line(s) cut at %d characters", cut, opts.MaxLineLength))
+	}
+
+	if len(notes) > 0 && strings.TrimSpace(stripAnsi(diff)) != "" {
+		diff = "(" + strings.Join(notes, "; ") + ")\n\n" + diff
+	}
+	return withModeChange(file, diff), nil
+}
+
+// normalizeText makes diff output safe to display: CRLF line endings become
+// LF, so a carriage return can't show as ^M or send the cursor back to the
+// start of the line, and bytes that aren't valid UTF-8, as in a Latin-1 file,
+// become U+FFFD rather than garbling the terminal. It returns a note for each
+// change made, for showing above the diff.
+func normalizeText(diff string) (string, []string) {
+	var notes []string
+	if strings.Contains(diff, "\r") {
+		diff = strings.ReplaceAll(diff, "\r", "")
+		notes = append(notes, "CRLF line endings shown as LF")
+	}
+	if !utf8.ValidString(diff) {
+		diff = strings.ToValidUTF8(diff, "\uFFFD")
+		notes = append(notes, "not UTF-8, undecodable bytes shown as \uFFFD")
+	}
+	return diff, notes
+}
+
+// truncateLongLines cuts each line of diff showing more than limit characters
+// down to limit, ending it with "…". Escape sequences don't count as
+// characters and are kept, with colors reset after the cut. It returns the
+// result and how many lines were cut; a limit of 0 cuts none.
+func truncateLongLines(diff string, limit int) (string, int) {
+	if limit <= 0 {
+		return diff, 0
+	}
+	lines := strings.Split(diff, "\n")
+	cut := 0
+	for i, line := range lines {
+		if len(line) <= limit {
+			continue // can't show more characters than it has bytes
+		}
+		if end := visibleCut(line, limit); end < len(line) {
+			lines[i] = line[:end] + "\x1b[0m…"
+			cut++
+		}
+	}
+	if cut == 0 {
+		return diff, 0
+	}
+	return strings.Join(lines, "\n"), cut
+}
+
+// visibleCut returns the byte offset in s just after its first n visible
+// characters, skipping ANSI escape sequences as stripAnsi does, or len(s) if
+// it has no more than n.
+func visibleCut(s string, n int) int {
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			i++
+			if i < len(s) && s[i] == '[' {
+				for i < len(s) && s[i] != 'm' {
+					i++
+				}
+				if i < len(s) {
+					i++
+				}
+			}
+			continue
+		}
+		if n == 0 {
+			return i
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n--
+	}
+	return len(s)
+}
+
+// withModeChange prefixes diff with a note about the file's mode change, which
+// stripDiffHeader removes along with the rest of the header. For mode-only
+// changes the note is the whole diff.
+func withModeChange(file ChangedFile, diff string) string {
+	if file.Mode == "" {
+		return diff
+	}
+	note := "mode changed " + file.Mode
+	if strings.TrimSpace(stripAnsi(diff)) == "" {
+		return note + "\n"
+	}
+	return note + "\n\n" + diff
+}
+
+// DiffShellCommand returns the bash pipeline GetDiff runs for file: git diff
+// rendered through opts.Pager, writing ANSI-colored output to stdout.
+// Untracked files are diffed against /dev/null, and files whose changes are
+// all staged show the staged diff.
+func DiffShellCommand(file ChangedFile, opts DiffOptions) string {
+	flags := "-U" + strconv.Itoa(opts.Context)
+	if opts.IgnoreWhitespace {
+		flags += " -w"
+	}
+	if file.Repo.Plain {
+		args, err := plainDiffArgs(file, flags)
+		if err != nil {
+			return "(echo " + shellQuote(err.Error()) + " >&2; exit 2)"
+		}
+		return diffCommand(file.Repo.Path, args, opts.Pager, opts.TabWidth)
+	}
+	args := flags + " -- " + shellQuote(file.Path)
+	if file.Staged() && !file.Unstaged() {
+		// Plain git diff compares the working tree to the index, which shows
+		// nothing once every change is staged
+		args = flags + " --cached -- " + shellQuote(file.Path)
+	}
+	if file.Status == "A" {
+		// An added file is usually staged, so diffing against the index would
+		// show nothing; diff from HEAD to include the whole file
+		args = flags + " " + diffBase(file.Repo.Path) + " -- " + shellQuote(file.Path)
+	}
+	if file.Status == "R" && file.OrigPath != "" {
+		// A rename is recorded in the index, so diff from HEAD and give git
+		// both paths to pair them up
+		args = flags + " -M " + diffBase(file.Repo.Path) + " -- " + shellQuote(file.OrigPath) + " " + shellQuote(file.Path)
+	}
+	switch file.Side {
+	case "staged":
+		args = flags + " --cached -- " + shellQuote(file.Path)
+		if file.Status == "R" && file.OrigPath != "" {
+			args = flags + " --cached -M -- " + shellQuote(file.OrigPath) + " " + shellQuote(file.Path)
+		}
+	case "unstaged":
+		// The working tree against the index, even for a new or renamed file
+		args = flags + " -- " + shellQuote(file.Path)
+	}
+	if file.Base != "" {
+		// Everything since the fork point: commits, staged and unstaged changes
+		args = flags + " " + file.Base + " -- " + shellQuote(file.Path)
+		if file.Status == "R" && file.OrigPath != "" {
+			args = flags + " -M " + file.Base + " -- " + shellQuote(file.OrigPath) + " " + shellQuote(file.Path)
+		}
+	}
+	if file.Status == "?" || file.Status == "!" {
+		absPath := filepath.Join(file.Repo.Path, file.Path)
+		args = flags + " --no-index /dev/null " + shellQuote(absPath)
+	}
+	return diffCommand(file.Repo.Path, args, opts.Pager, opts.TabWidth)
+}
+
+// diffBase returns what to diff the working tree or index against: "HEAD", or
+// the empty tree in a repo with no commits yet, where HEAD doesn't resolve.
+func diffBase(repoPath string) string {
+	base, _ := diffBaseCommit(context.Background(), repoPath)
+	return base
+}
+
+// diffBaseCommit is diffBase, also returning the object it names: the commit
+// HEAD points to, or the empty tree. Both git runs are killed if ctx is
+// canceled.
+func diffBaseCommit(ctx context.Context, repoPath string) (base, commit string) {
+	if out, err := runOutputContext(ctx, statusTimeout, gitBinary, "-C", repoPath, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		return "HEAD", strings.TrimSpace(string(out))
+	}
+	if ctx.Err() != nil {
+		return "HEAD", ""
+	}
+	// The empty tree's ID depends on the repo's hash algorithm, so ask git
+	out, err := runOutputContext(ctx, statusTimeout, gitBinary, "-C", repoPath, "hash-object", "-t", "tree", "/dev/null")
+	if err != nil {
+		return "HEAD", ""
+	}
+	tree := strings.TrimSpace(string(out))
+	return tree, tree
+}
+
+// GetRepoDiff returns an applyable patch of a repo's uncommitted changes to
+// tracked files, scoped to its WatchPath. With staged, only changes in the index
+// are included; otherwise the patch covers staged and unstaged changes against HEAD.
+func GetRepoDiff(repo *Repo, staged bool) (string, error) {
+	if repo.Plain {
+		return "", fmt.Errorf("%s is watched without git, so it has no patch to export", repo.Name)
+	}
+	args := []string{"-C", repo.Path, "--no-optional-locks", "diff", "--binary", "--no-color"}
+	if staged {
+		args = append(args, "--cached")
+	} else {
+		args = append(args, diffBase(repo.Path))
+	}
+	if repo.WatchPath != repo.Path {
+		if rel, err := filepath.Rel(repo.Path, repo.WatchPath); err == nil {
+			args = append(args, "--", rel)
+		}
+	}
+	out, err := runOutput(statusTimeout, gitBinary, args...)
+	if errors.Is(err, ErrTimeout) {
+		return "", fmt.Errorf("git diff %w", err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// StageHunk stages one hunk of a modified file's unstaged changes, like
+// choosing it in `git add -p`: the hunk of the diff GetDiff shows for file
+// with the same opts that covers line of the working-tree file, or the one
+// nearest to it. Hunks are found in git's own output, so it works whatever
+// pager renders the diff, even one that hides hunk headers. It returns the
+// staged hunk's index, counting from 0. Only files whose diff is against the
+// index (status M or D) can be staged this way.
+func StageHunk(file ChangedFile, line int, opts DiffOptions) (int, error) {
+	if file.Repo.Plain {
+		return -1, fmt.Errorf("%s is watched without git, so there is nothing to stage", file.Repo.Name)
+	}
+	if file.Status != "M" && file.Status != "D" {
+		return -1, fmt.Errorf("only modified or deleted files can be staged by hunk")
+	}
+	if opts.IgnoreWhitespace {
+		return -1, fmt.Errorf("can't stage hunks while whitespace changes are hidden")
+	}
+	if file.Base != "" {
+		return -1, fmt.Errorf("can't stage hunks of a diff against the merge base")
+	}
+	if file.Side == "staged" {
+		return -1, fmt.Errorf("%s is showing only its staged changes", file.Path)
+	}
+	if !file.Unstaged() {
+		return -1, fmt.Errorf("%s has no unstaged changes", file.Path)
+	}
+	out, err := runOutput(statusTimeout, gitBinary, "-C", file.Repo.Path, "--no-optional-locks",
+		"diff", "--no-color", "--no-ext-diff", "--src-prefix=a/", "--dst-prefix=b/",
+		"-U"+strconv.Itoa(opts.Context), "--", file.Path)
+	if err != nil {
+		return -1, err
+	}
+	hunk := hunkAt(string(out), line)
+	patch, ok := hunkPatch(string(out), hunk)
+	if !ok {
+		return -1, fmt.Errorf("no hunk to stage in the diff of %s; it may have changed", file.Path)
+	}
+
+	args := []string{"-C", file.Repo.Path, "apply", "--cached"}
+	if opts.Context == 0 {
+		args = append(args, "--unidiff-zero") // git apply refuses hunks without context otherwise
+	}
+	cmd := gitCommand(append(args, "-")...)
+	cmd.Stdin = strings.NewReader(patch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return -1, errors.New(msg)
+		}
+		return -1, err
+	}
+	return hunk, nil
+}
+
+// hunkRange matches a unified diff hunk header, capturing the new file's
+// starting line and line count.
+var hunkRange = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// hunkAt returns the index of diff's @@ hunk whose lines in the new file
+// cover line, or of the nearest one, or -1 if diff has no hunks. A hunk that
+// only removes lines covers the line that now stands in their place.
+func hunkAt(diff string, line int) int {
+	best, bestDist := -1, 0
+	n := -1
+	for _, l := range strings.Split(diff, "\n") {
+		match := hunkRange.FindStringSubmatch(l)
+		if match == nil {
+			continue
+		}
+		n++
+		start, _ := strconv.Atoi(match[1])
+		count := 1
+		if match[2] != "" {
+			count, _ = strconv.Atoi(match[2])
+		}
+		first, last := start, start+count-1
+		if count == 0 {
+			first, last = start+1, start+1
+		}
+		dist := 0
+		switch {
+		case line < first:
+			dist = first - line
+		case line > last:
+			dist = line - last
+		}
+		if best < 0 || dist < bestDist {
+			best, bestDist = n, dist
+		}
+	}
+	return best
+}
+
+// hunkPatch returns a patch holding diff's file header and only its hunk'th
+// @@ hunk, or false if diff has fewer hunks. A hunk's line numbers refer to
+// the unpatched file, so it applies on its own without the hunks before it.
+func hunkPatch(diff string, hunk int) (string, bool) {
+	var header, body []string
+	n := -1
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			n++
+		}
+		switch {
+		case n < 0:
+			header = append(header, line)
+		case n == hunk:
+			body = append(body, line)
+		}
+	}
+	if len(body) == 0 {
+		return "", false
+	}
+	return strings.Join(header, "") + strings.Join(body, ""), true
+}
+
+// diffCommand builds the shell pipeline that runs git diff with args (flags and
+// paths, already quoted) in repoPath and renders it with pager. Known backends
+// given as a bare name get the flags they need for non-interactive colored
+// output; anything else is used verbatim, except that delta is always given
+// tabWidth if it's set.
+func diffCommand(repoPath, args, pager string, tabWidth int) string {
+	git := shellQuote(gitBinary) + " -C " + shellQuote(repoPath) + " --no-optional-locks"
+	fields := strings.Fields(pager)
+	if len(fields) == 0 {
+		return git + " diff --color=always " + args
+	}
+
+	switch filepath.Base(fields[0]) {
+	case "delta":
+		if len(fields) == 1 {
+			pager += " " + deltaFlags
+		}
+		if tabWidth > 0 {
+			pager += " --tabs " + strconv.Itoa(tabWidth)
+		}
+		return git + " diff " + args + " | " + pager
+	case "difft", "difftastic":
+		// difftastic can't read a unified diff, so git runs it as an external diff tool
+		if len(fields) == 1 {
+			pager += " --color=always --display=inline"
+		}
+		return git + " -c diff.external=" + shellQuote(pager) + " diff --ext-diff " + args
+	default:
+		return git + " diff --color=always " + args + " | " + pager
+	}
+}
+
+// stripDiffHeader removes the git diff frontmatter (diff --git, index, mode, ---/+++ lines)
+// from the beginning of the output.
+func stripDiffHeader(s string) string {
+	lines := strings.Split(s, "\n")
+	start := 0
+	for start < len(lines) {
+		plain := stripAnsi(lines[start])
+		if strings.HasPrefix(plain, "diff --git ") ||
+			strings.HasPrefix(plain, "diff --cc ") ||
+			strings.HasPrefix(plain, "index ") ||
+			strings.HasPrefix(plain, "--- ") ||
+			strings.HasPrefix(plain, "+++ ") ||
+			strings.HasPrefix(plain, "new file mode") ||
+			strings.HasPrefix(plain, "old mode") ||
+			strings.HasPrefix(plain, "new mode") ||
+			strings.HasPrefix(plain, "deleted file mode") ||
+			strings.HasPrefix(plain, "similarity index") ||
+			strings.HasPrefix(plain, "rename from") ||
+			strings.HasPrefix(plain, "rename to") ||
+			plain == "" {
+			start++
+			continue
+		}
+		break
+	}
+	return strings.Join(lines[start:], "\n")
+}
+
+// stripAnsi removes ANSI escape sequences from a string for comparison purposes.
+func stripAnsi(s string) string {
+	var out strings.Builder
+	i := 0
+	for i < len(s) {
+		if s[i] == '\x1b' {
+			j := i + 1
+			if j < len(s) && s[j] == '[' {
+				j++
+				for j < len(s) && s[j] != 'm' {
+					j++
+				}
+				if j < len(s) {
+					j++
+				}
+			}
+			i = j
+		} else {
+			out.WriteByte(s[i])
+			i++
+		}
+	}
+	return out.String()
+}
+
+// shellQuote wraps a string in single quotes for safe shell interpolation.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "'\"'\"'") + "'"
+}
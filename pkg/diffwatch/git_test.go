@@ -0,0 +1,261 @@
+package diffwatch
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newGitRepo returns an empty git repo in a temp dir, set up to commit.
+func newGitRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	gitIn(t, dir, "init", "-q")
+	gitIn(t, dir, "config", "user.email", "test@example.com")
+	gitIn(t, dir, "config", "user.name", "test")
+	return dir
+}
+
+// gitIn runs git in dir, failing the test if it errors.
+func gitIn(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// writeFile writes content to path under dir, creating its directory.
+func writeFile(t *testing.T, dir, path, content string) {
+	t.Helper()
+	full := filepath.Join(dir, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestScopedStatusPaths checks a repo watched from a subdirectory lists its
+// modified, untracked and renamed files with their names as they are on disk,
+// spaces and non-ASCII characters included, and that each one's diff shows.
+func TestScopedStatusPaths(t *testing.T) {
+	dir := newGitRepo(t)
+	writeFile(t, dir, "sub/keep.txt", "keep\n")
+	writeFile(t, dir, "sub/old name.txt", "moved\n")
+	writeFile(t, dir, "outside.txt", "outside\n")
+	gitIn(t, dir, "add", "-A")
+	gitIn(t, dir, "commit", "-q", "-m", "init")
+
+	writeFile(t, dir, "sub/keep.txt", "keep\nmore\n")
+	gitIn(t, dir, "mv", "sub/old name.txt", "sub/new näme.txt")
+	writeFile(t, dir, "sub/new näme.txt", "moved\nedited\n")
+	writeFile(t, dir, "sub/with space.txt", "spaced\n")
+	writeFile(t, dir, "sub/ünïcødé.txt", "unicode\n")
+	writeFile(t, dir, "outside.txt", "outside\nchanged\n")
+
+	repo := &Repo{Name: "r", Path: dir, WatchPath: filepath.Join(dir, "sub")}
+	status, err := GetRepoStatus(repo, StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]ChangedFile)
+	for _, f := range status.Files {
+		got[f.Path] = f
+	}
+	if len(got) != 4 {
+		t.Errorf("got %d files, want 4: %v", len(got), status.Files)
+	}
+
+	tests := []struct {
+		path, status, origPath, line string
+	}{
+		{"sub/keep.txt", "M", "", "+more"},
+		{"sub/new näme.txt", "R", "sub/old name.txt", "+edited"},
+		{"sub/with space.txt", "?", "", "+spaced"},
+		{"sub/ünïcødé.txt", "?", "", "+unicode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			f, ok := got[tt.path]
+			if !ok {
+				t.Fatalf("%q not listed", tt.path)
+			}
+			if f.Status != tt.status || f.OrigPath != tt.origPath {
+				t.Errorf("status %q from %q, want %q from %q", f.Status, f.OrigPath, tt.status, tt.origPath)
+			}
+			diff, err := GetDiff(f, DiffOptions{Context: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(stripAnsi(diff), tt.line) {
+				t.Errorf("diff doesn't show %q:\n%s", tt.line, diff)
+			}
+		})
+	}
+}
+
+// TestGetDiffNormalizesText checks a CRLF file's diff has no carriage returns
+// and a Latin-1 file's invalid bytes become U+FFFD, each with a note above the
+// diff and the header still stripped.
+func TestGetDiffNormalizesText(t *testing.T) {
+	tests := []struct {
+		name, before, after string
+		note, line          string
+	}{
+		{"crlf.txt", "one\r\n", "one\r\ntwo\r\n", "CRLF line endings shown as LF", "+two"},
+		{"latin1.txt", "one\n", "one\ncaf\xe9\n", "not UTF-8", "+caf\uFFFD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newGitRepo(t)
+			writeFile(t, dir, tt.name, tt.before)
+			gitIn(t, dir, "add", "-A")
+			gitIn(t, dir, "commit", "-q", "-m", "init")
+			writeFile(t, dir, tt.name, tt.after)
+
+			repo := &Repo{Name: "r", Path: dir, WatchPath: dir}
+			file := ChangedFile{Repo: repo, Path: tt.name, Status: "M", XY: " M"}
+			diff, err := GetDiff(file, DiffOptions{Context: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain := stripAnsi(diff)
+			if strings.Contains(plain, "\r") {
+				t.Errorf("diff has a carriage return: %q", plain)
+			}
+			if !strings.HasPrefix(plain, "("+tt.note) {
+				t.Errorf("diff doesn't start with the note %q: %q", tt.note, plain)
+			}
+			if strings.Contains(plain, "diff --git") || strings.Contains(plain, "+++ ") {
+				t.Errorf("diff header wasn't stripped: %q", plain)
+			}
+			if !strings.Contains(plain, tt.line) {
+				t.Errorf("diff doesn't show %q: %q", tt.line, plain)
+			}
+		})
+	}
+}
+
+// TestNoCommits checks a repo with no commits yet diffs against the empty
+// tree: staged files get line counts and diffs, as untracked ones do.
+func TestNoCommits(t *testing.T) {
+	dir := newGitRepo(t)
+	writeFile(t, dir, "staged.txt", "one\ntwo\n")
+	writeFile(t, dir, "untracked.txt", "three\n")
+	gitIn(t, dir, "add", "staged.txt")
+
+	emptyTree := strings.TrimSpace(gitIn(t, dir, "hash-object", "-t", "tree", "/dev/null"))
+	if base := diffBase(dir); base != emptyTree {
+		t.Errorf("diffBase = %q, want the empty tree %q", base, emptyTree)
+	}
+
+	repo := &Repo{Name: "r", Path: dir, WatchPath: dir}
+	status, err := GetRepoStatus(repo, StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Files) != 2 {
+		t.Fatalf("got %d files, want 2: %v", len(status.Files), status.Files)
+	}
+	staged, untracked := status.Files[0], status.Files[1]
+	if staged.Status != "A" || staged.Added != 2 || staged.Removed != 0 {
+		t.Errorf("staged file = %s +%d -%d, want A +2 -0", staged.Status, staged.Added, staged.Removed)
+	}
+	if untracked.Status != "?" {
+		t.Errorf("untracked file status = %q, want ?", untracked.Status)
+	}
+	for _, f := range status.Files {
+		diff, err := GetDiff(f, DiffOptions{Context: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(stripAnsi(diff)) == "" {
+			t.Errorf("%s has an empty diff", f.Path)
+		}
+	}
+}
+
+// TestDiscoverFile checks a file inside a repo, given as an absolute path or
+// relative to the working directory, is watched on its own: Path is the repo
+// root and WatchPath the file.
+func TestDiscoverFile(t *testing.T) {
+	dir := newGitRepo(t)
+	writeFile(t, dir, "sub/file.txt", "one\n")
+	// t.TempDir may sit behind a symlink, as on macOS; compare real paths
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "sub", "file.txt")
+
+	t.Chdir(filepath.Join(dir, "sub"))
+	for _, arg := range []string{file, "file.txt", "./file.txt"} {
+		repos, err := DiscoverRepos(arg, DiscoverOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", arg, err)
+		}
+		if len(repos) != 1 {
+			t.Fatalf("%s: got %d repos, want 1", arg, len(repos))
+		}
+		if repos[0].Path != dir || repos[0].WatchPath != file {
+			t.Errorf("%s: Path %q, WatchPath %q; want %q, %q", arg, repos[0].Path, repos[0].WatchPath, dir, file)
+		}
+	}
+}
+
+// TestModeChanges checks git diff --raw --numstat -z output is parsed into
+// each file's mode change, and that only a file whose content is unchanged is
+// marked ModeOnly.
+func TestModeChanges(t *testing.T) {
+	dir := newGitRepo(t)
+	gitIn(t, dir, "config", "core.fileMode", "true")
+	writeFile(t, dir, "chmod.sh", "echo one\n")
+	writeFile(t, dir, "both.sh", "echo one\n")
+	gitIn(t, dir, "add", "-A")
+	gitIn(t, dir, "commit", "-q", "-m", "init")
+
+	for _, name := range []string{"chmod.sh", "both.sh"} {
+		if err := os.Chmod(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, dir, "both.sh", "echo one\necho two\n")
+
+	out := gitIn(t, dir, "diff", "HEAD", "--raw", "--numstat", "--no-renames", "-z")
+	modes, counts := parseRawNumstat(out)
+	repo := &Repo{Name: "r", Path: dir, WatchPath: dir}
+	status, err := GetRepoStatus(repo, StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]ChangedFile)
+	for _, f := range status.Files {
+		got[f.Path] = f
+	}
+
+	tests := []struct {
+		path     string
+		added    int
+		modeOnly bool
+	}{
+		{"chmod.sh", 0, true},
+		{"both.sh", 1, false},
+	}
+	const mode = "100644 → 100755"
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if modes[tt.path] != mode || counts[tt.path].added != tt.added {
+				t.Errorf("parsed mode %q, +%d; want %q, +%d", modes[tt.path], counts[tt.path].added, mode, tt.added)
+			}
+			f := got[tt.path]
+			if f.Mode != mode || f.ModeOnly != tt.modeOnly || f.Added != tt.added {
+				t.Errorf("Mode %q, ModeOnly %t, +%d; want %q, %t, +%d", f.Mode, f.ModeOnly, f.Added, mode, tt.modeOnly, tt.added)
+			}
+		})
+	}
+}
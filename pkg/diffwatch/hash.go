@@ -0,0 +1,111 @@
+package diffwatch
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxHashSize is the largest untracked file whose content is hashed; larger
+// ones get no DiffHash.
+const maxHashSize = 1 << 20
+
+// applyDiffHashes sets DiffHash on files: a hash of the lines their diff
+// against base adds and removes, leaving out the header and hunk positions,
+// so the same edit made in two clones hashes the same even if the files
+// differ elsewhere. One git diff covers the whole repo; with no base, as
+// when only untracked files changed, it's skipped and only new files are
+// hashed. Errors are ignored; the files then have no hash.
+func applyDiffHashes(ctx context.Context, repo *Repo, files []ChangedFile, base string) {
+	if len(files) == 0 {
+		return
+	}
+	hashes := make(map[string]string)
+	if base != "" {
+		diffHashes(ctx, repo, base, hashes)
+	}
+	for i, f := range files {
+		switch f.Status {
+		case "?", "!", "A":
+			// A new file is hashed from its content whether or not it's
+			// staged, so the same new file matches across clones either way
+			files[i].DiffHash = contentHash(filepath.Join(repo.Path, f.Path))
+		default:
+			files[i].DiffHash = hashes[f.Path]
+		}
+	}
+}
+
+// diffHashes adds the hash of each file's diff against base to hashes.
+func diffHashes(ctx context.Context, repo *Repo, base string, hashes map[string]string) {
+	args := []string{"-C", repo.Path, "--no-optional-locks", "-c", "core.quotePath=false",
+		"diff", "--no-color", "--no-ext-diff", "--no-renames", "--src-prefix=a/", "--dst-prefix=b/", "-U0", base}
+	if repo.WatchPath != repo.Path {
+		if rel, err := filepath.Rel(repo.Path, repo.WatchPath); err == nil {
+			args = append(args, "--", rel)
+		}
+	}
+	out, err := runOutputContext(ctx, statusTimeout, gitBinary, args...)
+	if err != nil {
+		return
+	}
+
+	var path string
+	var h hash.Hash
+	header := false // between a diff --git line and its first @@, where the paths are
+	flush := func() {
+		if path != "" && h != nil {
+			hashes[path] = hex.EncodeToString(h.Sum(nil))
+		}
+		path, h = "", nil
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			h = sha256.New()
+			header = true
+		case h == nil:
+			// before the first file
+		case header && strings.HasPrefix(line, "@@"):
+			header = false
+		case header && strings.HasPrefix(line, "--- "), header && strings.HasPrefix(line, "+++ "):
+			// git ends a path containing spaces with a tab; /dev/null is the
+			// missing side of an added or deleted file
+			name := strings.TrimSuffix(line[len("--- "):], "\t")
+			if _, rel, ok := strings.Cut(name, "/"); ok && name != "/dev/null" {
+				path = rel
+			}
+		case header && (strings.HasPrefix(line, "Binary files ") || strings.HasPrefix(line, "new mode ")),
+			!header && (strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-")):
+			io.WriteString(h, line+"\n")
+		}
+	}
+	flush()
+}
+
+// contentHash returns a hash of the file at path as a new file's diff, or ""
+// if it can't be read or is too large.
+func contentHash(path string) string {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxHashSize {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	io.WriteString(h, "new file\n")
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
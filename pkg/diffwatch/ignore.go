@@ -0,0 +1,146 @@
+package diffwatch
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IgnoreFileName is the per-repo file, in gitignore syntax, listing changed
+// files diffwatch should hide. It lives at the repo root and is independent of
+// .gitignore, so teams can share exclusions without affecting git.
+const IgnoreFileName = ".diffwatchignore"
+
+// ignorePattern is a single parsed line of an ignore file.
+type ignorePattern struct {
+	glob     string // slash-separated pattern without leading/trailing slashes or "!"
+	negate   bool   // "!pattern" re-includes a previously ignored path
+	dirOnly  bool   // "pattern/" only matches directories
+	anchored bool   // pattern contains a slash, so it matches from the repo root
+}
+
+// ignoreRules caches the parsed ignore file for a repo.
+type ignoreRules struct {
+	modTime  time.Time
+	patterns []ignorePattern
+}
+
+// ignoreCache holds parsed ignore files keyed by repo root. GetChangedFiles
+// runs from several goroutines, so access is guarded.
+var ignoreCache = struct {
+	sync.Mutex
+	rules map[string]*ignoreRules
+}{rules: make(map[string]*ignoreRules)}
+
+// loadIgnorePatterns returns the ignore patterns for the repo at repoPath,
+// re-parsing the ignore file only when its modification time changes.
+func loadIgnorePatterns(repoPath string) []ignorePattern {
+	ignoreCache.Lock()
+	defer ignoreCache.Unlock()
+
+	file := filepath.Join(repoPath, IgnoreFileName)
+	info, err := os.Stat(file)
+	if err != nil {
+		delete(ignoreCache.rules, repoPath)
+		return nil
+	}
+	if cached, ok := ignoreCache.rules[repoPath]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.patterns
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	rules := &ignoreRules{modTime: info.ModTime(), patterns: parseIgnore(string(data))}
+	ignoreCache.rules[repoPath] = rules
+	return rules.patterns
+}
+
+// parseIgnore parses gitignore-style content into patterns.
+func parseIgnore(content string) []ignorePattern {
+	var patterns []ignorePattern
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\") // "\#" and "\!" escape a literal first character
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		p.glob = line
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// isIgnored reports whether relPath (slash-separated, relative to the repo root)
+// is excluded by patterns. Later patterns override earlier ones, as in git.
+func isIgnored(patterns []ignorePattern, relPath string) bool {
+	ignored := false
+	for _, p := range patterns {
+		if p.matches(relPath) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// matches reports whether the pattern matches relPath or any of its parent directories.
+func (p ignorePattern) matches(relPath string) bool {
+	parts := strings.Split(relPath, "/")
+	for i := 1; i <= len(parts); i++ {
+		isDir := i < len(parts)
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.anchored {
+			if globMatch(strings.Split(p.glob, "/"), parts[:i]) {
+				return true
+			}
+		} else if ok, _ := path.Match(p.glob, parts[i-1]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches path segments against pattern segments, where a "**"
+// segment matches zero or more path segments.
+func globMatch(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if globMatch(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
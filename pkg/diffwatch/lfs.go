@@ -0,0 +1,108 @@
+package diffwatch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// usesLFS reports whether the repo at repoPath tracks any files with Git LFS,
+// judging by its top-level .gitattributes. Checking that one file keeps
+// repos without LFS, the common case, from paying for git check-attr on
+// every poll.
+func usesLFS(repoPath string) bool {
+	data, err := os.ReadFile(filepath.Join(repoPath, ".gitattributes"))
+	return err == nil && strings.Contains(string(data), "filter=lfs")
+}
+
+// applyLFS sets LFS on the files git stores with the LFS filter. Errors are
+// ignored; the files are then shown as ordinary ones.
+func applyLFS(ctx context.Context, repo *Repo, files []ChangedFile) {
+	if len(files) == 0 || !usesLFS(repo.Path) {
+		return
+	}
+	var stdin strings.Builder
+	for _, f := range files {
+		stdin.WriteString(f.Path)
+		stdin.WriteByte(0)
+	}
+	cmd, _, cancel := timedCommand(ctx, statusTimeout, gitBinary, "-C", repo.Path, "check-attr", "-z", "--stdin", "filter")
+	defer cancel()
+	cmd.Stdin = strings.NewReader(stdin.String())
+	out, err := cmd.Output()
+	if err != nil {
+		return
+	}
+	lfs := make(map[string]bool)
+	// Output is path NUL attribute NUL value NUL for each path
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == "lfs" {
+			lfs[fields[i]] = true
+		}
+	}
+	for i := range files {
+		files[i].LFS = lfs[files[i].Path]
+	}
+}
+
+// lfsNote stands in for the diff of an LFS file, which would only show the
+// pointer's hash and size changing. It gives the object's size in the
+// committed pointer and in the working tree, where LFS keeps the real content.
+func lfsNote(file ChangedFile) string {
+	var before, after string
+	if file.Status != "A" && file.Status != "?" && file.Status != "!" {
+		base := file.Base
+		if base == "" {
+			base = diffBase(file.Repo.Path)
+		}
+		path := file.Path
+		if file.OrigPath != "" {
+			path = file.OrigPath
+		}
+		if out, err := runOutput(statusTimeout, gitBinary, "-C", file.Repo.Path, "cat-file", "blob", base+":"+path); err == nil {
+			for _, line := range strings.Split(string(out), "\n") {
+				if size, ok := strings.CutPrefix(line, "size "); ok {
+					before = size
+				}
+			}
+		}
+	}
+	if file.Status != "D" {
+		if info, err := os.Stat(filepath.Join(file.Repo.Path, file.Path)); err == nil {
+			after = strconv.FormatInt(info.Size(), 10)
+		}
+	}
+	note := "Git LFS object (content not shown)"
+	switch {
+	case before != "" && after != "" && before != after:
+		note += fmt.Sprintf(": %s → %s", formatSize(before), formatSize(after))
+	case after != "":
+		note += ": " + formatSize(after)
+	case before != "":
+		note += ": " + formatSize(before)
+	}
+	return note + "\n"
+}
+
+// formatSize renders a byte count like "1.5 MB", or returns bytes as it is if
+// it isn't a number.
+func formatSize(bytes string) string {
+	n, err := strconv.ParseFloat(bytes, 64)
+	if err != nil {
+		return bytes
+	}
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1000 && i < len(units)-1 {
+		n /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
@@ -0,0 +1,238 @@
+package diffwatch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSnapshotFiles stops a plain directory snapshot from walking a huge tree,
+// like a home directory passed by mistake.
+const maxSnapshotFiles = 20000
+
+// maxSnapshotSize is the largest file whose content is kept in a snapshot.
+// Larger files are still reported when their size or modification time
+// changes, but can't be diffed.
+const maxSnapshotSize = 1 << 20
+
+// maxSnapshotBytes caps the content copied into one snapshot in total, so a
+// tree of many files under maxSnapshotSize can't fill the temporary
+// directory. Files past it are treated like ones over maxSnapshotSize.
+const maxSnapshotBytes = 256 << 20
+
+// snapshotFile is the state of one file when a snapshot was taken.
+type snapshotFile struct {
+	size    int64
+	modTime time.Time
+	copied  bool // its content was copied into the snapshot directory
+}
+
+// snapshot records the files of a plain (non-git) directory at startup, so
+// later polls can report what was created, modified, or deleted since. Copies
+// of the files' content are kept in a temporary directory for diffing.
+type snapshot struct {
+	dir   string                  // temporary directory holding the copies, laid out like the original
+	files map[string]snapshotFile // path relative to the watched directory -> state at startup
+}
+
+// snapshots holds the snapshot of each plain repo, keyed by its Path.
+// GetRepoStatus runs from several goroutines, so access is guarded.
+var snapshots = struct {
+	sync.Mutex
+	byPath map[string]*snapshot
+}{byPath: make(map[string]*snapshot)}
+
+// takeSnapshot records the files under root. A root already snapshotted, e.g.
+// when repos are rediscovered after a config change, keeps its snapshot so
+// changes are still shown since launch.
+func takeSnapshot(root string) error {
+	snapshots.Lock()
+	_, ok := snapshots.byPath[root]
+	snapshots.Unlock()
+	if ok {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "diffwatch-snapshot-")
+	if err != nil {
+		return err
+	}
+	snap := &snapshot{dir: dir, files: make(map[string]snapshotFile)}
+	var copied int64 // bytes copied so far, against maxSnapshotBytes
+	err = walkPlain(root, func(rel string, info fs.FileInfo) error {
+		if len(snap.files) >= maxSnapshotFiles {
+			return fmt.Errorf("%s has more than %d files, too many to snapshot", root, maxSnapshotFiles)
+		}
+		file := snapshotFile{size: info.Size(), modTime: info.ModTime()}
+		if info.Size() <= maxSnapshotSize && copied+info.Size() <= maxSnapshotBytes {
+			file.copied = copyFile(filepath.Join(root, rel), filepath.Join(dir, rel)) == nil
+			if file.copied {
+				copied += info.Size()
+			}
+		}
+		snap.files[rel] = file
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	snapshots.Lock()
+	defer snapshots.Unlock()
+	snapshots.byPath[root] = snap
+	return nil
+}
+
+// RemoveSnapshots deletes the copies kept for plain directories. Call it
+// before exiting when DiscoverOptions.NoGit was used.
+func RemoveSnapshots() {
+	snapshots.Lock()
+	defer snapshots.Unlock()
+	for path, snap := range snapshots.byPath {
+		os.RemoveAll(snap.dir)
+		delete(snapshots.byPath, path)
+	}
+}
+
+// walkPlain calls fn for each regular file under root with its path relative
+// to root. .git directories are skipped, as are files fn can't stat.
+func walkPlain(root string, fn func(rel string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip what can't be read
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		return fn(filepath.ToSlash(rel), info)
+	})
+}
+
+// copyFile copies src to dst, creating dst's directory.
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// plainStatus lists the files of a plain repo created ("A"), modified ("M"),
+// or deleted ("D") since its snapshot was taken, filtered by the repo's
+// ignore file.
+func plainStatus(repo *Repo) ([]ChangedFile, error) {
+	snapshots.Lock()
+	snap, ok := snapshots.byPath[repo.Path]
+	snapshots.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no snapshot of %s", repo.Path)
+	}
+
+	ignore := loadIgnorePatterns(repo.Path)
+	var files []ChangedFile
+	add := func(rel, status string) {
+		if !isIgnored(ignore, rel) {
+			files = append(files, ChangedFile{Repo: repo, Path: rel, Status: status})
+		}
+	}
+	seen := make(map[string]bool, len(snap.files))
+	err := walkPlain(repo.Path, func(rel string, info fs.FileInfo) error {
+		seen[rel] = true
+		old, ok := snap.files[rel]
+		switch {
+		case !ok:
+			add(rel, "A")
+		case info.Size() == old.size && info.ModTime().Equal(old.modTime):
+			// unchanged
+		case !old.copied || info.Size() != old.size || !sameContent(filepath.Join(repo.Path, rel), filepath.Join(snap.dir, rel)):
+			add(rel, "M")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for rel := range snap.files {
+		if !seen[rel] {
+			add(rel, "D")
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// sameContent reports whether the files at a and b have the same content.
+func sameContent(a, b string) bool {
+	da, err := os.ReadFile(a)
+	if err != nil {
+		return false
+	}
+	db, err := os.ReadFile(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(da, db)
+}
+
+// plainDiffArgs returns the git diff --no-index arguments comparing a plain
+// repo's file to its snapshot copy, or an error if the snapshot has no copy.
+// git diff --no-index works outside a repository, so the diff is rendered
+// like any other.
+func plainDiffArgs(file ChangedFile, flags string) (string, error) {
+	snapshots.Lock()
+	snap, ok := snapshots.byPath[file.Repo.Path]
+	snapshots.Unlock()
+	if !ok {
+		return "", fmt.Errorf("no snapshot of %s", file.Repo.Path)
+	}
+	current := filepath.Join(file.Repo.Path, file.Path)
+	if file.Status == "A" {
+		return flags + " --no-index /dev/null " + shellQuote(current), nil
+	}
+	if old := snap.files[file.Path]; !old.copied {
+		if old.size > maxSnapshotSize {
+			return "", fmt.Errorf("%s was over %d KiB at startup, so there's no snapshot to diff against", file.Path, maxSnapshotSize>>10)
+		}
+		return "", fmt.Errorf("the snapshot of %s reached %d MiB before %s, so there's no copy to diff against", file.Repo.Name, maxSnapshotBytes>>20, file.Path)
+	}
+	original := filepath.Join(snap.dir, filepath.FromSlash(file.Path))
+	if file.Status == "D" {
+		return flags + " --no-index " + shellQuote(original) + " /dev/null", nil
+	}
+	return flags + " --no-index " + shellQuote(original) + " " + shellQuote(current), nil
+}
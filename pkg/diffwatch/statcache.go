@@ -0,0 +1,93 @@
+package diffwatch
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// statsEntry is what GetRepoStatus last worked out for a watched tree with
+// git diff: each file's line counts, mode change and DiffHash.
+type statsEntry struct {
+	key   [sha256.Size]byte // what the stats were worked out from, see statsKey
+	files map[string]ChangedFile
+}
+
+// statsCache holds the stats of each watched tree keyed by WatchPath, so a
+// poll that finds the same git status output as the last one skips the git
+// diffs behind them. GetRepoStatus runs from several goroutines, so access
+// is guarded.
+var statsCache = struct {
+	sync.Mutex
+	entries map[string]*statsEntry
+}{entries: make(map[string]*statsEntry)}
+
+// statsKey identifies everything the stats of files depend on: git status's
+// output, the commit they're diffed against, whether hashes are wanted, and
+// the size and modification time of each file, which change when it's edited
+// without its status changing. base and commit are empty while only untracked
+// files changed, leaving the status and the files to go by.
+func statsKey(repo *Repo, status []byte, base, commit string, files []ChangedFile, opts StatusOptions) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(status)
+	fmt.Fprintf(h, "\x00%s\x00%s\x00%t\x00", base, commit, opts.HashDiffs)
+	for _, f := range files {
+		info, err := os.Lstat(filepath.Join(repo.Path, f.Path))
+		if err != nil {
+			fmt.Fprintf(h, "%s\x00-\x00", f.Path)
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%d %d %v\x00", f.Path, info.Size(), info.ModTime().UnixNano(), info.Mode())
+	}
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// cachedStats fills in files' stats from the cache and returns true if they
+// were worked out from the same key; otherwise it leaves files alone.
+func cachedStats(repo *Repo, key [sha256.Size]byte, files []ChangedFile) bool {
+	statsCache.Lock()
+	defer statsCache.Unlock()
+	entry, ok := statsCache.entries[repo.WatchPath]
+	if !ok || entry.key != key {
+		return false
+	}
+	for i, f := range files {
+		cached := entry.files[f.Path]
+		files[i].Added, files[i].Removed = cached.Added, cached.Removed
+		files[i].Mode, files[i].ModeOnly = cached.Mode, cached.ModeOnly
+		files[i].DiffHash = cached.DiffHash
+	}
+	return true
+}
+
+// storeStats caches the stats of files under key.
+func storeStats(repo *Repo, key [sha256.Size]byte, files []ChangedFile) {
+	entry := &statsEntry{key: key, files: make(map[string]ChangedFile, len(files))}
+	for _, f := range files {
+		entry.files[f.Path] = f
+	}
+	statsCache.Lock()
+	defer statsCache.Unlock()
+	statsCache.entries[repo.WatchPath] = entry
+}
+
+// pruneStats drops the cached stats of trees not in repos, so repos a
+// Watcher stops polling don't keep their file lists in memory. Another caller
+// of GetRepoStatus that loses its entry only pays for the git diffs again.
+func pruneStats(repos []Repo) {
+	watched := make(map[string]bool, len(repos))
+	for _, r := range repos {
+		watched[r.WatchPath] = true
+	}
+	statsCache.Lock()
+	defer statsCache.Unlock()
+	for path := range statsCache.entries {
+		if !watched[path] {
+			delete(statsCache.entries, path)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package diffwatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Change reports the current set of changed files for a repo.
+type Change struct {
+	Repo  *Repo
+	Files []ChangedFile
+	State string // in-progress operation, see RepoState
+	Err   error  // set if the repo couldn't be read; Files and State are then empty
+
+	Ahead, Behind int // commits ahead of and behind the upstream branch, see RepoStatus
+
+	// Pending marks a notice that Repo has changed but SetCoalesce is holding
+	// the report back; only Repo is set, and a full Change follows once the
+	// window passes. Without coalescing, no notices are sent.
+	Pending bool
+}
+
+// Watcher polls git repos for changes on a regular interval.
+type Watcher struct {
+	// changes is only sent on and closed by pollLoop, so it can't be sent on
+	// after it's closed. Every send also selects on ctx.Done(), so a reader
+	// that stopped reading, e.g. a UI quitting, can't leave pollLoop blocked.
+	changes chan Change
+	ctx     context.Context // canceled by Close or by the caller's parent context
+	cancel  context.CancelFunc
+
+	polling sync.WaitGroup // held by pollLoop, so Close can wait for it to exit
+
+	mu    sync.Mutex // guards repos, opts, stats, coalesce and reconcile
+	repos []Repo
+	opts  StatusOptions
+	stats Stats
+
+	coalesce  time.Duration // minimum time between two changes reported for a repo, see SetCoalesce
+	reconcile time.Duration // how often a poll rereads everything, see SetReconcile
+}
+
+// Stats describes the work a Watcher has done, for diagnostics.
+type Stats struct {
+	Repos    int           // repos being polled
+	Polls    int           // completed passes over all repos
+	Failures int           // git status runs that failed
+	Changes  int           // changes delivered on the Changes channel, not counting pending notices
+	LastPoll time.Duration // how long the most recent pass took
+	MaxPoll  time.Duration // the slowest pass so far
+}
+
+// NewWatcher creates a Watcher that polls the given repos for changes, listing
+// each repo's files as GetChangedFiles does with opts. The watcher stops when
+// ctx is canceled, as if Close were called, and a git status running then is
+// killed.
+func NewWatcher(ctx context.Context, repos []Repo, opts StatusOptions) (*Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Watcher{
+		repos:   repos,
+		opts:    opts,
+		changes: make(chan Change, 64),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	w.polling.Add(1)
+	go w.pollLoop()
+
+	return w, nil
+}
+
+// pollLoop periodically runs git status on all repos and sends changes.
+// It closes the changes channel when the watcher's context is canceled.
+func (w *Watcher) pollLoop() {
+	defer w.polling.Done()
+	defer close(w.changes)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	// Track each repo's last reported state to detect changes. Only pollLoop
+	// touches it, so it needs no lock.
+	reported := make(map[string]*repoReport) // repo path -> its last reported change
+	lastFull := time.Now()                   // when a poll last reread everything
+
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			repos, opts, coalesce, reconcile := w.config()
+			pruneReports(reported, repos)
+			pruneStats(repos)
+			full := reconcile > 0 && start.Sub(lastFull) >= reconcile
+			if full {
+				lastFull = start
+			}
+			for i := range repos {
+				if w.ctx.Err() != nil {
+					return // don't start another git status once closed
+				}
+				change := Change{Repo: &repos[i]}
+				var fingerprint string
+				status, err := getRepoStatus(w.ctx, &repos[i], opts, full)
+				if w.ctx.Err() != nil {
+					return // canceled mid-run, not a failure of the repo
+				}
+				if err != nil {
+					w.record(func(s *Stats) { s.Failures++ })
+					// Errors are reported once, until the repo recovers or fails differently
+					change.Err = err
+					fingerprint = "error\n" + err.Error()
+				} else {
+					// Build a fingerprint of current state
+					change.Files = status.Files
+					change.State = RepoState(&repos[i])
+					change.Ahead, change.Behind = status.Ahead, status.Behind
+					fingerprint = fmt.Sprintf("%s\n%d %d\n%s", change.State, status.Ahead, status.Behind, fileFingerprint(status.Files))
+				}
+				last := reported[repos[i].WatchPath]
+				switch {
+				case last == nil:
+				case fingerprint == last.fingerprint && !last.held:
+					continue // no change
+				case fingerprint == last.fingerprint:
+					// Changed back while held; report the state again so the
+					// pending notice is withdrawn
+				case time.Since(last.at) < coalesce:
+					// Held back; the last report is left alone so a later
+					// poll reports whatever state the repo has settled in by
+					// then. Each repo's window runs on its own, so a busy
+					// repo doesn't hold back a quiet one.
+					if !last.held {
+						last.held = true
+						if !w.send(Change{Repo: &repos[i], Pending: true}) {
+							return
+						}
+					}
+					continue
+				}
+				reported[repos[i].WatchPath] = &repoReport{fingerprint: fingerprint, at: time.Now()}
+				if !w.send(change) {
+					return
+				}
+				w.record(func(s *Stats) { s.Changes++ })
+			}
+			elapsed := time.Since(start)
+			w.record(func(s *Stats) {
+				s.Polls++
+				s.LastPoll = elapsed
+				s.MaxPoll = max(s.MaxPoll, elapsed)
+			})
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// send delivers change, returning false if the watcher was closed first.
+func (w *Watcher) send(change Change) bool {
+	select {
+	case w.changes <- change:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+// repoReport is the last change pollLoop reported for a repo.
+type repoReport struct {
+	fingerprint string    // repo state and concatenated file state
+	at          time.Time // when it was reported, for the coalescing window
+	held        bool      // a later change is held back and a pending notice was sent
+}
+
+// pruneReports forgets the reports of repos no longer watched, so a repo that
+// SetRepos drops and later adds back is reported afresh rather than compared
+// to what it looked like before.
+func pruneReports(reported map[string]*repoReport, repos []Repo) {
+	watched := make(map[string]bool, len(repos))
+	for _, r := range repos {
+		watched[r.WatchPath] = true
+	}
+	for path := range reported {
+		if !watched[path] {
+			delete(reported, path)
+		}
+	}
+}
+
+// SetStatusOptions changes how files are listed from the next poll on.
+func (w *Watcher) SetStatusOptions(opts StatusOptions) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.opts = opts
+}
+
+// SetCoalesce makes the watcher report a repo at most once per window d, so a
+// burst of index writes, as during a rebase, produces a couple of updates
+// rather than one per poll. Changes within the window are batched into the
+// state reported once it has passed, announced by a Change with Pending set
+// when they're first held back. 0, the default, reports every poll.
+func (w *Watcher) SetCoalesce(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.coalesce = d
+}
+
+// SetReconcile makes every poll once per interval d reread what the others
+// take from a cache while a repo's git status and files look unchanged: line
+// counts, mode changes and diff hashes. It's a safety net for edits the
+// quick check misses, such as one that keeps a file's size and lands within
+// its filesystem's timestamp resolution. Only real differences are reported.
+// 0, the default, never rereads.
+func (w *Watcher) SetReconcile(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.reconcile = d
+}
+
+// Stats returns a snapshot of the watcher's activity so far.
+func (w *Watcher) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.stats
+	s.Repos = len(w.repos)
+	return s
+}
+
+// record updates the stats under the lock.
+func (w *Watcher) record(update func(*Stats)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	update(&w.stats)
+}
+
+// SetRepos replaces the watched repos from the next poll on. Repos that were
+// already watched are only reported again once they change; repos added,
+// including ones watched before and since dropped, are reported on that poll.
+func (w *Watcher) SetRepos(repos []Repo) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.repos = repos
+}
+
+// config returns the repos, options, coalescing window and reconcile interval
+// for the current poll.
+func (w *Watcher) config() ([]Repo, StatusOptions, time.Duration, time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.repos, w.opts, w.coalesce, w.reconcile
+}
+
+// fileFingerprint builds a string representing the current changed-file state.
+func fileFingerprint(files []ChangedFile) string {
+	if len(files) == 0 {
+		return ""
+	}
+	var b []byte
+	for _, f := range files {
+		b = append(b, f.Status...)
+		b = append(b, f.XY...)
+		b = append(b, ':')
+		b = append(b, f.Path...)
+		b = append(b, ':')
+		b = append(b, f.Mode...)
+		b = append(b, ':')
+		b = append(b, f.DiffHash...)
+		b = fmt.Appendf(b, ":%d:%d\n", f.Added, f.Removed)
+	}
+	return string(b)
+}
+
+// Changes returns the channel on which changes are delivered. Only repos whose
+// changed-file state differs from the previous poll are reported. The channel
+// is closed once the watcher stops.
+func (w *Watcher) Changes() <-chan Change {
+	return w.changes
+}
+
+// Close shuts down the watcher by canceling its context. It returns once the
+// poll goroutine has exited, after killing any git status it was running, so
+// nothing reads a repo or a snapshot after Close returns. A change held back
+// by SetCoalesce is dropped. Close is safe to call more than once, and from
+// several goroutines.
+func (w *Watcher) Close() {
+	w.cancel()
+	w.polling.Wait()
+}
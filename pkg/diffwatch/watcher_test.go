@@ -0,0 +1,231 @@
+package diffwatch
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRepo returns a git repo in a temp dir with one untracked file.
+func testRepo(t *testing.T) Repo {
+	t.Helper()
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Repo{Name: "r", Path: dir, WatchPath: dir}
+}
+
+// TestCloseDuringPoll closes a watcher while its git status is running and
+// checks Close kills it and returns, Changes is closed, and a second Close
+// does nothing.
+func TestCloseDuringPoll(t *testing.T) {
+	repo := testRepo(t)
+
+	// A git that says when it starts, then hangs well past the test's limit
+	started := filepath.Join(t.TempDir(), "started")
+	slowGit := filepath.Join(t.TempDir(), "git")
+	script := "#!/bin/sh\ntouch " + started + "\nexec sleep 30\n"
+	if err := os.WriteFile(slowGit, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	SetGitBinary(slowGit)
+	defer SetGitBinary("git")
+
+	w, err := NewWatcher(context.Background(), []Repo{repo}, StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the watcher never ran git status")
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't return while git status was running")
+	}
+
+	select {
+	case change, ok := <-w.Changes():
+		if ok {
+			t.Fatalf("got a change after Close: %+v", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Changes wasn't closed by Close")
+	}
+
+	again := make(chan struct{})
+	go func() {
+		w.Close()
+		close(again)
+	}()
+	select {
+	case <-again:
+	case <-time.After(time.Second):
+		t.Fatal("a second Close didn't return")
+	}
+}
+
+// TestConcurrentLifecycle runs Close, SetRepos and Stats from several
+// goroutines while changes are being delivered, for go test -race to check
+// the locking and channel ownership the Watcher's comments describe.
+func TestConcurrentLifecycle(t *testing.T) {
+	repos := []Repo{testRepo(t), testRepo(t)}
+	w, err := NewWatcher(context.Background(), repos, StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep both repos changing, so each poll has something to deliver
+	stop := make(chan struct{})
+	var editing sync.WaitGroup
+	editing.Add(1)
+	go func() {
+		defer editing.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+			for _, r := range repos {
+				os.WriteFile(filepath.Join(r.Path, "f"), []byte(strconv.Itoa(i)+"\n"), 0o644)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		editing.Wait()
+	}()
+
+	received := make(chan int)
+	go func() {
+		n := 0
+		for range w.Changes() {
+			n++
+		}
+		received <- n
+	}()
+
+	// Let a few polls deliver changes, then race everything against Close
+	time.Sleep(2500 * time.Millisecond)
+	var callers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		callers.Add(3)
+		go func() {
+			defer callers.Done()
+			for j := 0; j < 50; j++ {
+				w.SetRepos(repos[:1+j%2])
+			}
+		}()
+		go func() {
+			defer callers.Done()
+			for j := 0; j < 50; j++ {
+				w.Stats()
+			}
+		}()
+		go func() {
+			defer callers.Done()
+			time.Sleep(time.Duration(i) * 10 * time.Millisecond)
+			w.Close()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		callers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close, SetRepos or Stats didn't return")
+	}
+
+	select {
+	case n := <-received:
+		if n == 0 {
+			t.Error("no changes were delivered before Close")
+		}
+		if stats := w.Stats(); stats.Changes != n {
+			t.Errorf("Stats counted %d changes, the channel delivered %d", stats.Changes, n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Changes wasn't closed by Close")
+	}
+}
+
+// TestCoalescePendingNotice checks that a change held back by SetCoalesce is
+// announced once with a pending notice, then reported when the window passes.
+func TestCoalescePendingNotice(t *testing.T) {
+	repo := testRepo(t)
+	w, err := NewWatcher(context.Background(), []Repo{repo}, StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.SetCoalesce(3 * time.Second)
+
+	next := func() Change {
+		t.Helper()
+		select {
+		case change := <-w.Changes():
+			return change
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change reported")
+			return Change{}
+		}
+	}
+	if first := next(); first.Pending || len(first.Files) != 1 {
+		t.Fatalf("first report = %+v, want the untracked file", first)
+	}
+
+	if err := os.WriteFile(filepath.Join(repo.Path, "g"), []byte("two\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if notice := next(); !notice.Pending || notice.Files != nil {
+		t.Fatalf("second report = %+v, want a pending notice", notice)
+	}
+	if held := next(); held.Pending || len(held.Files) != 2 {
+		t.Fatalf("third report = %+v, want both files", held)
+	}
+}
+
+// TestPruneStats checks the stats of a repo no longer polled are dropped and
+// those of one still polled are kept.
+func TestPruneStats(t *testing.T) {
+	kept, dropped := testRepo(t), testRepo(t)
+	for _, repo := range []Repo{kept, dropped} {
+		if _, err := GetRepoStatus(&repo, StatusOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pruneStats([]Repo{kept})
+
+	statsCache.Lock()
+	defer statsCache.Unlock()
+	if statsCache.entries[kept.WatchPath] == nil {
+		t.Error("the polled repo's stats were dropped")
+	}
+	if statsCache.entries[dropped.WatchPath] != nil {
+		t.Error("the dropped repo's stats were kept")
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
+)
+
+// repoSource records where the watched paths came from, so they can be
+// resolved again after the config changes.
+type repoSource struct {
+	profiles []string // saved profile names, resolved from the config each time
+	paths    []string // literal paths from the command line
+}
+
+// newRepoSource interprets the command-line arguments. Each argument naming a
+// saved profile stands for that profile's paths, and any other argument is a
+// path. A profile name wins over a directory of the same name unless the
+// argument is written as a path, e.g. "./work". No arguments load the
+// "default" profile, or fall back to ".".
+func newRepoSource(args []string) repoSource {
+	if len(args) == 0 {
+		if resolveProfile("default") != nil {
+			return repoSource{profiles: []string{"default"}}
+		}
+		return repoSource{paths: []string{"."}}
+	}
+	var source repoSource
+	for _, arg := range args {
+		if !looksLikePath(arg) && resolveProfile(arg) != nil {
+			source.profiles = append(source.profiles, arg)
+		} else {
+			source.paths = append(source.paths, arg)
+		}
+	}
+	return source
+}
+
+// looksLikePath reports whether arg is clearly meant as a path rather than a
+// profile name: it has a directory separator or is "." or "..".
+func looksLikePath(arg string) bool {
+	return arg == "." || arg == ".." || strings.ContainsRune(arg, '/') || strings.ContainsRune(arg, filepath.Separator)
+}
+
+// profile returns the saved profile being watched if it is the only source
+// of paths, or "".
+func (s repoSource) profile() string {
+	if len(s.profiles) == 1 && len(s.paths) == 0 {
+		return s.profiles[0]
+	}
+	return ""
+}
+
+// resolve returns the paths to watch, reading the profiles from the config.
+// A path listed more than once, e.g. by two profiles, is returned once.
+func (s repoSource) resolve() ([]string, error) {
+	paths := s.paths
+	if len(s.profiles) > 0 {
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, fmt.Errorf("could not load config: %w", err)
+		}
+		paths = nil
+		for _, name := range s.profiles {
+			profilePaths, ok := cfg.Profiles[name]
+			if !ok {
+				return nil, fmt.Errorf("profile '%s' not found", name)
+			}
+			for _, p := range profilePaths {
+				paths = append(paths, expandPath(p))
+			}
+		}
+		paths = append(paths, s.paths...)
+	}
+
+	seen := make(map[string]bool, len(paths))
+	var unique []string
+	for _, p := range paths {
+		key := p
+		if abs, err := filepath.Abs(p); err == nil {
+			key = abs
+		}
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, p)
+		}
+	}
+	return unique, nil
+}
+
+// discoverAll finds the repos under each path. Paths that can't be scanned or
+// hold no repos are described in problems, each prefixed "Error:" or
+// "Warning:", and the rest are still scanned. With progress, a running count is
+// printed to stderr during slow walks. Leading directories shared by every
+// repo's name are removed and returned as prefix, unless opts.NameTemplate
+// names the repos.
+func discoverAll(paths []string, opts Options, progress bool) (repos []diffwatch.Repo, prefix string, problems []string) {
+	for _, path := range paths {
+		if err := checkPath(path); err != nil {
+			problems = append(problems, fmt.Sprintf("Error: %v", err))
+			continue
+		}
+		discoverOpts := diffwatch.DiscoverOptions{
+			FollowSymlinks: opts.FollowSymlinks,
+			Submodules:     opts.Submodules,
+			MaxRepos:       opts.MaxRepos,
+			MaxDepth:       opts.MaxDepth,
+			NameTemplate:   opts.NameTemplate,
+			NoGit:          opts.NoGit,
+		}
+		progressShown := false
+		if progress {
+			discoverOpts.Progress = func(found int) {
+				fmt.Fprintf(os.Stderr, "\rScanning %s... found %d repo(s)", path, found)
+				progressShown = true
+			}
+		}
+		found, err := diffwatch.DiscoverRepos(path, discoverOpts)
+		if progressShown {
+			fmt.Fprint(os.Stderr, "\r\x1b[K") // clear the progress line
+		}
+		if errors.Is(err, diffwatch.ErrRepoLimit) {
+			problems = append(problems, fmt.Sprintf("Warning: %s: %v; use --max-repos to raise the limit", path, err))
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("Warning: could not scan %s: %v", path, err))
+			continue
+		}
+		if len(found) == 0 {
+			problems = append(problems, fmt.Sprintf("Warning: %s: no git repositories found", path))
+		}
+		repos = append(repos, found...)
+	}
+	repos = excludeRepos(uniqueRepos(repos), opts.Exclude)
+	if !opts.Submodules {
+		var skipped []string
+		repos, skipped = skipSubmodules(repos)
+		problems = append(problems, skipped...)
+	}
+	repos, remote := remoteRepos(repos, opts.SkipRemote)
+	problems = append(problems, remote...)
+	if opts.NameTemplate == "" {
+		prefix = stripCommonPrefix(repos)
+	}
+	return repos, prefix, problems
+}
+
+// stripCommonPrefix removes the leading directories all repo names share, e.g.
+// "shopify/" from "shopify/billing" and "shopify/core", and returns them. Every
+// name keeps at least its last element, and a lone repo keeps its full name.
+func stripCommonPrefix(repos []diffwatch.Repo) string {
+	if len(repos) < 2 {
+		return ""
+	}
+	common := strings.Split(repos[0].Name, "/")
+	common = common[:len(common)-1]
+	for _, repo := range repos[1:] {
+		parts := strings.Split(repo.Name, "/")
+		n := 0
+		for n < len(common) && n < len(parts)-1 && parts[n] == common[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) == 0 {
+		return ""
+	}
+	prefix := strings.Join(common, "/") + "/"
+	for i := range repos {
+		repos[i].Name = strings.TrimPrefix(repos[i].Name, prefix)
+	}
+	return prefix
+}
+
+// uniqueRepos drops repeats of a repo found under more than one of the
+// watched paths, e.g. ~/src and ~/src/app, keeping the first.
+func uniqueRepos(repos []diffwatch.Repo) []diffwatch.Repo {
+	seen := make(map[string]bool, len(repos))
+	unique := repos[:0]
+	for _, repo := range repos {
+		if !seen[repo.WatchPath] {
+			seen[repo.WatchPath] = true
+			unique = append(unique, repo)
+		}
+	}
+	return unique
+}
+
+// skipSubmodules drops repos that are submodules of another repo in repos,
+// whose status already reports them, and describes each one dropped.
+func skipSubmodules(repos []diffwatch.Repo) ([]diffwatch.Repo, []string) {
+	var problems []string
+	var kept []diffwatch.Repo
+	for _, repo := range repos {
+		skip := false
+		for _, parent := range repos {
+			if !parent.Plain && diffwatch.IsSubmoduleOf(repo, parent) {
+				problems = append(problems, fmt.Sprintf("Warning: skipped %s, a submodule of %s, which already lists it; use --submodules to watch both", repo.Name, parent.Name))
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			kept = append(kept, repo)
+		}
+	}
+	return kept, problems
+}
+
+// excludeRepos drops the repos whose display name matches any of patterns.
+func excludeRepos(repos []diffwatch.Repo, patterns []string) []diffwatch.Repo {
+	if len(patterns) == 0 {
+		return repos
+	}
+	kept := repos[:0]
+	for _, repo := range repos {
+		if !matchesAny(repo.Name, patterns) {
+			kept = append(kept, repo)
+		}
+	}
+	return kept
+}
+
+// remoteRepos describes each repo on a network filesystem, where every git
+// status is slow. With skip, those repos are also left out.
+func remoteRepos(repos []diffwatch.Repo, skip bool) ([]diffwatch.Repo, []string) {
+	var problems []string
+	kept := repos[:0]
+	for _, repo := range repos {
+		fs := diffwatch.RemoteFilesystem(repo.Path)
+		switch {
+		case fs == "":
+			kept = append(kept, repo)
+		case skip:
+			problems = append(problems, fmt.Sprintf("Warning: skipped %s, which is on a network filesystem (%s)", repo.Name, fs))
+		default:
+			problems = append(problems, fmt.Sprintf("Warning: %s is on a network filesystem (%s), so polling it may be slow; --skip-remote leaves it out", repo.Name, fs))
+			kept = append(kept, repo)
+		}
+	}
+	return kept, problems
+}
+
+// matchesAny reports whether name matches any of the filepath.Match patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reposReloadedMsg carries the result of rediscovering repos after a config reload.
+type reposReloadedMsg struct {
+	repos    []diffwatch.Repo
+	prefix   string // stripped from the repo names, see discoverAll
+	problems []string
+	err      error
+}
+
+// reloadRepos returns a tea.Cmd that re-reads the config, resolves source's
+// paths again, and rediscovers their repos.
+func reloadRepos(source repoSource, opts Options) tea.Cmd {
+	return func() tea.Msg {
+		if _, err := loadConfig(); err != nil {
+			return reposReloadedMsg{err: fmt.Errorf("could not load config: %w", err)}
+		}
+		paths, err := source.resolve()
+		if err != nil {
+			return reposReloadedMsg{err: err}
+		}
+		repos, prefix, problems := discoverAll(paths, opts, false)
+		return reposReloadedMsg{repos: repos, prefix: prefix, problems: problems}
+	}
+}
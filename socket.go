@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
+)
+
+// fileEvent is how a changed file appears in a socket event.
+type fileEvent struct {
+	Path     string `json:"path"`
+	OrigPath string `json:"origPath,omitempty"`
+	Status   string `json:"status"`
+	XY       string `json:"xy"`
+}
+
+// repoEvent is one line of the socket's event stream: a repo's state after the
+// watcher saw it change. Files lists everything changed in the repo, not just
+// what changed since the last event.
+type repoEvent struct {
+	Repo   string      `json:"repo"` // display name
+	Path   string      `json:"path"` // the watched path (WatchPath), unique per repo
+	Root   string      `json:"root"` // the git root
+	Files  []fileEvent `json:"files"`
+	State  string      `json:"state,omitempty"`
+	Ahead  int         `json:"ahead,omitempty"`
+	Behind int         `json:"behind,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// eventWriteTimeout is how long a write to a client may block before the
+// client is given up on.
+const eventWriteTimeout = 5 * time.Second
+
+// eventClientBuffer is how many events a client may fall behind by before it's
+// disconnected, so a stuck client can't stall the others or the UI.
+const eventClientBuffer = 64
+
+// eventServer streams change events as newline-delimited JSON to every client
+// connected to a Unix socket, for editor integrations.
+type eventServer struct {
+	listener net.Listener
+	path     string
+
+	mu      sync.Mutex // guards clients and last
+	clients map[chan []byte]bool
+	last    map[string][]byte // each repo's last event by WatchPath, sent to new clients
+}
+
+// listenEvents starts an eventServer on the Unix socket at path, replacing a
+// socket left behind by an earlier run. Other files at path are not touched.
+func listenEvents(path string) (*eventServer, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &eventServer{listener: listener, path: path, clients: make(map[chan []byte]bool), last: make(map[string][]byte)}
+	go s.accept()
+	return s, nil
+}
+
+// accept serves each new connection until the listener is closed. A new
+// client first gets each repo's last event, in path order, so it starts from
+// the current state rather than waiting for the next change.
+func (s *eventServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		// Room for the snapshot on top of the usual buffer
+		events := make(chan []byte, eventClientBuffer+len(s.last))
+		paths := make([]string, 0, len(s.last))
+		for path := range s.last {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			events <- s.last[path]
+		}
+		s.clients[events] = true
+		s.mu.Unlock()
+		go s.serve(conn, events)
+	}
+}
+
+// serve writes events to conn until either side goes away.
+func (s *eventServer) serve(conn net.Conn, events chan []byte) {
+	defer conn.Close()
+	defer s.drop(events)
+	for line := range events {
+		conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		if _, err := conn.Write(line); err != nil {
+			return
+		}
+	}
+}
+
+// drop unregisters a client, closing its channel if Publish hasn't already.
+func (s *eventServer) drop(events chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[events] {
+		delete(s.clients, events)
+		close(events)
+	}
+}
+
+// Publish sends msg to every connected client. Clients too far behind are
+// disconnected rather than waited for.
+func (s *eventServer) Publish(msg FilesChangedMsg) {
+	event := repoEvent{
+		Repo:   msg.Repo.Name,
+		Path:   msg.Repo.WatchPath,
+		Root:   msg.Repo.Path,
+		Files:  []fileEvent{},
+		State:  msg.State,
+		Ahead:  msg.Ahead,
+		Behind: msg.Behind,
+	}
+	if msg.Err != nil {
+		event.Error = msg.Err.Error()
+	}
+	for _, f := range msg.Files {
+		event.Files = append(event.Files, fileEvent{Path: f.Path, OrigPath: f.OrigPath, Status: f.Status, XY: f.XY})
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last[msg.Repo.WatchPath] = line
+	for events := range s.clients {
+		select {
+		case events <- line:
+		default:
+			delete(s.clients, events)
+			close(events)
+		}
+	}
+}
+
+// RetainRepos forgets the last events of repos no longer watched, so new
+// clients aren't told about them.
+func (s *eventServer) RetainRepos(repos []diffwatch.Repo) {
+	watched := make(map[string]bool, len(repos))
+	for _, r := range repos {
+		watched[r.WatchPath] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path := range s.last {
+		if !watched[path] {
+			delete(s.last, path)
+		}
+	}
+}
+
+// Close stops accepting clients, disconnects the current ones, and removes
+// the socket file.
+func (s *eventServer) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for events := range s.clients {
+		delete(s.clients, events)
+		close(events)
+	}
+	s.mu.Unlock()
+	os.Remove(s.path)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
+)
+
+// TestSocketSnapshot checks a client connecting after a change was published
+// is sent each watched repo's last state, and not that of a repo since dropped.
+func TestSocketSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.sock")
+	s, err := listenEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	a := &diffwatch.Repo{Name: "a", Path: "/a", WatchPath: "/a"}
+	b := &diffwatch.Repo{Name: "b", Path: "/b", WatchPath: "/b"}
+	s.Publish(FilesChangedMsg{Repo: a, Files: []diffwatch.ChangedFile{{Repo: a, Path: "old", Status: "M", XY: " M"}}})
+	s.Publish(FilesChangedMsg{Repo: a, Files: []diffwatch.ChangedFile{{Repo: a, Path: "new", Status: "?", XY: "??"}}})
+	s.Publish(FilesChangedMsg{Repo: b})
+	s.RetainRepos([]diffwatch.Repo{*a})
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event repoEvent
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Path != "/a" || len(event.Files) != 1 || event.Files[0].Path != "new" {
+		t.Errorf("got %+v, want a's last state with only new", event)
+	}
+
+	// Nothing else is waiting: b was dropped, and a was sent once
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 1)); n > 0 || err == nil {
+		t.Error("got more than a's snapshot")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// Summary renders an overview of the changed repos for the diff panel while
+// no file is selected: each repo's file count and added and removed lines,
+// then the totals. It fits in width columns and height rows.
+func (m FileTreeModel) Summary(width, height int) string {
+	faintStyle := lipgloss.NewStyle().Faint(true)
+	addedStyle := lipgloss.NewStyle().Foreground(m.theme.Added)
+	removedStyle := lipgloss.NewStyle().Foreground(m.theme.Deleted)
+	stateStyle := lipgloss.NewStyle().Bold(true).Foreground(m.theme.State)
+
+	type row struct {
+		name                  string
+		state                 string
+		files, added, removed int
+	}
+	var rows []row
+	var total row
+	for _, rg := range m.repos {
+		r := row{name: rg.Repo.Name, state: rg.State}
+		for _, f := range rg.Files {
+			if m.noUntracked && f.Status == "?" {
+				continue
+			}
+			r.files++
+			r.added += f.Added
+			r.removed += f.Removed
+		}
+		if r.files == 0 {
+			continue
+		}
+		rows = append(rows, r)
+		total.files += r.files
+		total.added += r.added
+		total.removed += r.removed
+	}
+	if len(rows) == 0 {
+		return faintStyle.Padding(1, 2).Render("No changes to summarize.\nWatching for changes...")
+	}
+
+	countWidth := len(fmt.Sprint(total.files))
+	counts := func(r row) string {
+		return fmt.Sprintf("%*d %-5s  %s %s", countWidth, r.files, plural(r.files, "file", "files"),
+			addedStyle.Render(fmt.Sprintf("+%d", r.added)), removedStyle.Render(fmt.Sprintf("-%d", r.removed)))
+	}
+	nameWidth := len("Total")
+	for _, r := range rows {
+		nameWidth = max(nameWidth, ansi.StringWidth(r.name))
+	}
+	// Leave room for the counts; long names lose their start, like in the tree
+	nameWidth = min(nameWidth, max(width-4-ansi.StringWidth(counts(total)), 8))
+
+	line := func(name, state string, r row) string {
+		name = truncateLeft(name, nameWidth)
+		s := "  " + name + strings.Repeat(" ", nameWidth-ansi.StringWidth(name)) + "  " + counts(r)
+		if state != "" {
+			s += " " + stateStyle.Render("["+state+"]")
+		}
+		return s
+	}
+
+	lines := []string{"", faintStyle.Render("  Select a file to view its diff"), ""}
+	// Keep the total line and its separator on screen when repos overflow
+	shown := len(rows)
+	if room := height - len(lines) - 2; shown > room {
+		shown = max(room-1, 0) // one row says how many more there are
+	}
+	for _, r := range rows[:shown] {
+		lines = append(lines, line(r.name, r.state, r))
+	}
+	if shown < len(rows) {
+		lines = append(lines, faintStyle.Render(fmt.Sprintf("  … %d more", len(rows)-shown)))
+	}
+	if len(rows) > 1 {
+		lines = append(lines, "", line("Total", "", total))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// plural returns one if n is 1 and many otherwise.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme names accepted by --theme and the "theme" config key.
+const (
+	ThemeAuto  = "auto"  // pick dark or light from the terminal background
+	ThemeDark  = "dark"  // for dark terminal backgrounds
+	ThemeLight = "light" // for light terminal backgrounds
+)
+
+// Theme holds the colors used across the UI, so palettes can be swapped in one place.
+type Theme struct {
+	Accent   lipgloss.Color // focused border, repo headers
+	Muted    lipgloss.Color // unfocused border
+	Warning  lipgloss.Color // pins, filter bar, PAUSED
+	Error    lipgloss.Color // error messages
+	State    lipgloss.Color // in-progress operations like [REBASING]
+	Conflict lipgloss.Color // conflict marker background
+	OnColor  lipgloss.Color // text drawn on a Conflict background
+	Fresh    lipgloss.Color // background of files that just appeared in the tree
+
+	Modified  lipgloss.Color
+	Added     lipgloss.Color
+	Deleted   lipgloss.Color
+	Renamed   lipgloss.Color
+	Untracked lipgloss.Color
+
+	// StatusColors overrides the color of individual status letters, e.g.
+	// "M" or "?", from the "statusColors" config key.
+	StatusColors map[string]lipgloss.Color
+}
+
+// darkTheme uses the terminal's bright ANSI colors, which read well on dark backgrounds.
+var darkTheme = Theme{
+	Accent:    lipgloss.Color("12"), // bright blue
+	Muted:     lipgloss.Color("8"),  // gray
+	Warning:   lipgloss.Color("11"), // bright yellow
+	Error:     lipgloss.Color("1"),  // red
+	State:     lipgloss.Color("5"),  // magenta
+	Conflict:  lipgloss.Color("5"),
+	OnColor:   lipgloss.Color("15"), // white
+	Fresh:     lipgloss.Color("23"), // dark teal
+	Modified:  lipgloss.Color("3"),  // yellow
+	Added:     lipgloss.Color("2"),  // green
+	Deleted:   lipgloss.Color("1"),  // red
+	Renamed:   lipgloss.Color("6"),  // cyan
+	Untracked: lipgloss.Color("8"),  // gray
+}
+
+// lightTheme swaps the bright colors, which wash out on white, for darker 256-color shades.
+var lightTheme = Theme{
+	Accent:    lipgloss.Color("25"),  // dark blue
+	Muted:     lipgloss.Color("245"), // mid gray
+	Warning:   lipgloss.Color("130"), // dark orange
+	Error:     lipgloss.Color("124"), // dark red
+	State:     lipgloss.Color("90"),  // dark magenta
+	Conflict:  lipgloss.Color("90"),
+	OnColor:   lipgloss.Color("15"),
+	Fresh:     lipgloss.Color("194"), // pale green
+	Modified:  lipgloss.Color("136"), // dark yellow
+	Added:     lipgloss.Color("28"),  // dark green
+	Deleted:   lipgloss.Color("124"), // dark red
+	Renamed:   lipgloss.Color("30"),  // dark cyan
+	Untracked: lipgloss.Color("245"), // mid gray
+}
+
+// NewTheme returns the palette for name. ThemeAuto queries the terminal, so
+// call it before the TUI takes over the screen.
+func NewTheme(name string) Theme {
+	switch name {
+	case ThemeDark:
+		return darkTheme
+	case ThemeLight:
+		return lightTheme
+	}
+	if lipgloss.HasDarkBackground() {
+		return darkTheme
+	}
+	return lightTheme
+}
+
+// statusLetters are the file statuses whose color can be configured.
+var statusLetters = map[string]bool{"M": true, "A": true, "D": true, "R": true, "C": true, "?": true, "!": true, "U": true}
+
+// hexColor matches colors written as #rgb or #rrggbb.
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// WithStatusColors returns t with the status letter colors in colors, each an
+// ANSI color index ("0" to "255") or a hex color ("#ff8700"). Entries with an
+// unknown letter or invalid color are skipped and reported in the error; the
+// rest still apply.
+func (t Theme) WithStatusColors(colors map[string]string) (Theme, error) {
+	if len(colors) == 0 {
+		return t, nil
+	}
+	overrides := make(map[string]lipgloss.Color, len(colors))
+	var invalid []string
+	for letter, color := range colors {
+		if !statusLetters[letter] {
+			invalid = append(invalid, fmt.Sprintf("%q is not a status letter", letter))
+			continue
+		}
+		if n, err := strconv.Atoi(color); (err != nil || n < 0 || n > 255) && !hexColor.MatchString(color) {
+			invalid = append(invalid, fmt.Sprintf("%s: %q is not a color index 0-255 or #rrggbb", letter, color))
+			continue
+		}
+		overrides[letter] = lipgloss.Color(color)
+	}
+	t.StatusColors = overrides
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return t, fmt.Errorf("invalid statusColors: %s", strings.Join(invalid, "; "))
+	}
+	return t, nil
+}
+
+// StatusStyle returns the style for a file status glyph.
+func (t Theme) StatusStyle(status string) lipgloss.Style {
+	style := lipgloss.NewStyle()
+	if color, ok := t.StatusColors[status]; ok {
+		style = style.Foreground(color)
+		switch status {
+		case "!":
+			return style.Faint(true)
+		case "U":
+			return style.Bold(true)
+		}
+		return style
+	}
+	switch status {
+	case "M":
+		return style.Foreground(t.Modified)
+	case "A":
+		return style.Foreground(t.Added)
+	case "D":
+		return style.Foreground(t.Deleted)
+	case "R":
+		return style.Foreground(t.Renamed)
+	case "?":
+		return style.Foreground(t.Untracked)
+	case "!":
+		return style.Faint(true).Foreground(t.Untracked)
+	case "U":
+		return style.Bold(true).Foreground(t.Conflict)
+	}
+	return style
+}
@@ -1,106 +1,43 @@
 package main
 
 import (
-	"time"
-
 	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/shopify-playground/richpoirier-diffwatch/pkg/diffwatch"
 )
 
 // FilesChangedMsg is sent when a repo's changed files have been refreshed.
 type FilesChangedMsg struct {
-	Repo  *Repo
-	Files []ChangedFile
-}
-
-// Watcher polls git repos for changes on a regular interval.
-type Watcher struct {
-	repos []Repo
-	msgCh chan FilesChangedMsg
-	done  chan struct{}
-}
-
-// NewWatcher creates a Watcher that polls the given repos for changes.
-func NewWatcher(repos []Repo) (*Watcher, error) {
-	w := &Watcher{
-		repos: repos,
-		msgCh: make(chan FilesChangedMsg, 64),
-		done:  make(chan struct{}),
-	}
-
-	go w.pollLoop()
-
-	return w, nil
-}
-
-// pollLoop periodically runs git status on all repos and sends changes.
-func (w *Watcher) pollLoop() {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+	Repo  *diffwatch.Repo
+	Files []diffwatch.ChangedFile
+	State string // in-progress operation such as "MERGING", or ""
+	Err   error  // set if the repo couldn't be read
 
-	// Track previous state to detect changes
-	prev := make(map[string]string) // repo path -> concatenated file state
+	Ahead, Behind int // commits ahead of and behind the upstream branch
 
-	for {
-		select {
-		case <-ticker.C:
-			for i := range w.repos {
-				files, err := GetChangedFiles(&w.repos[i])
-				if err != nil {
-					continue
-				}
+	// Pending marks a notice that the repo changed but --coalesce holds the
+	// report back; nothing else is set. See diffwatch.Change.Pending.
+	Pending bool
 
-				// Build a fingerprint of current state
-				fingerprint := fileFingerprint(files)
-				if fingerprint == prev[w.repos[i].WatchPath] {
-					continue // no change
-				}
-				prev[w.repos[i].WatchPath] = fingerprint
-
-				select {
-				case w.msgCh <- FilesChangedMsg{Repo: &w.repos[i], Files: files}:
-				case <-w.done:
-					return
-				}
-			}
-		case <-w.done:
-			return
-		}
-	}
+	watcher *diffwatch.Watcher // the watcher that reported it; nil for explicit scans
 }
 
-// fileFingerprint builds a string representing the current changed-file state.
-func fileFingerprint(files []ChangedFile) string {
-	if len(files) == 0 {
-		return ""
-	}
-	var b []byte
-	for _, f := range files {
-		b = append(b, f.Status...)
-		b = append(b, ':')
-		b = append(b, f.Path...)
-		b = append(b, '\n')
-	}
-	return string(b)
-}
-
-// WaitForChange returns a tea.Cmd that blocks until the next change is detected.
-func (w *Watcher) WaitForChange() tea.Cmd {
+// waitForChange returns a tea.Cmd that blocks until the watcher reports the next change.
+func waitForChange(w *diffwatch.Watcher) tea.Cmd {
 	return func() tea.Msg {
-		select {
-		case msg := <-w.msgCh:
-			return msg
-		case <-w.done:
+		change, ok := <-w.Changes()
+		if !ok {
 			return nil
 		}
-	}
-}
-
-// Close shuts down the watcher.
-func (w *Watcher) Close() {
-	select {
-	case <-w.done:
-		return // already closed
-	default:
-		close(w.done)
+		return FilesChangedMsg{
+			Repo:    change.Repo,
+			Files:   change.Files,
+			State:   change.State,
+			Err:     change.Err,
+			Ahead:   change.Ahead,
+			Behind:  change.Behind,
+			Pending: change.Pending,
+			watcher: w,
+		}
 	}
 }